@@ -0,0 +1,188 @@
+package coiot
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	coapVersion = 1
+
+	CoapTypeConfirmable    = 0
+	CoapTypeNonConfirmable = 1
+	CoapTypeAcknowledgment = 2
+	CoapTypeReset          = 3
+
+	CoapCodeGet     = 0x01
+	CoapCodePost    = 0x02
+	CoapCodeContent = 0x45
+
+	CoapOptionUriPath = 11
+
+	// shelly specific CoIoT options
+	CoapOptionShellyDeviceId = 3332
+	CoapOptionShellyValidity = 3412
+	CoapOptionShellySerial   = 3420
+
+	coapPayloadMarker = 0xFF
+)
+
+type (
+	coapOption struct {
+		Number int
+		Value  []byte
+	}
+
+	coapMessage struct {
+		Type      int
+		Code      int
+		MessageId uint16
+		Token     []byte
+		Options   []coapOption
+		Payload   []byte
+	}
+)
+
+// parseCoapMessage decodes a CoAP message (RFC 7252)
+func parseCoapMessage(data []byte) (*coapMessage, error) {
+	if len(data) < 4 {
+		return nil, errors.New(`coap message too short`)
+	}
+
+	if version := int(data[0] >> 6); version != coapVersion {
+		return nil, fmt.Errorf(`unsupported coap version %v`, version)
+	}
+
+	msg := coapMessage{
+		Type:      int(data[0]>>4) & 0x03,
+		Code:      int(data[1]),
+		MessageId: binary.BigEndian.Uint16(data[2:4]),
+	}
+
+	tokenLength := int(data[0] & 0x0F)
+	if tokenLength > 8 || len(data) < 4+tokenLength {
+		return nil, errors.New(`invalid coap token length`)
+	}
+	msg.Token = data[4 : 4+tokenLength]
+
+	pos := 4 + tokenLength
+	optionNumber := 0
+	for pos < len(data) {
+		if data[pos] == coapPayloadMarker {
+			msg.Payload = data[pos+1:]
+			break
+		}
+
+		delta := int(data[pos] >> 4)
+		length := int(data[pos] & 0x0F)
+		pos++
+
+		var err error
+		if delta, pos, err = parseCoapOptionValue(data, pos, delta); err != nil {
+			return nil, err
+		}
+		if length, pos, err = parseCoapOptionValue(data, pos, length); err != nil {
+			return nil, err
+		}
+
+		if pos+length > len(data) {
+			return nil, errors.New(`invalid coap option length`)
+		}
+
+		optionNumber += delta
+		msg.Options = append(msg.Options, coapOption{
+			Number: optionNumber,
+			Value:  data[pos : pos+length],
+		})
+		pos += length
+	}
+
+	return &msg, nil
+}
+
+// parseCoapOptionValue decodes the extended option delta/length
+func parseCoapOptionValue(data []byte, pos, value int) (int, int, error) {
+	switch value {
+	case 13:
+		if pos+1 > len(data) {
+			return 0, pos, errors.New(`invalid coap option`)
+		}
+		return int(data[pos]) + 13, pos + 1, nil
+	case 14:
+		if pos+2 > len(data) {
+			return 0, pos, errors.New(`invalid coap option`)
+		}
+		return int(binary.BigEndian.Uint16(data[pos:pos+2])) + 269, pos + 2, nil
+	case 15:
+		return 0, pos, errors.New(`invalid coap option (reserved value)`)
+	}
+
+	return value, pos, nil
+}
+
+// Option returns the first option value with the option number
+func (m *coapMessage) Option(number int) ([]byte, bool) {
+	for _, option := range m.Options {
+		if option.Number == number {
+			return option.Value, true
+		}
+	}
+	return nil, false
+}
+
+// UriPath returns the uri path of the message (eg. /cit/s)
+func (m *coapMessage) UriPath() string {
+	var parts []string
+	for _, option := range m.Options {
+		if option.Number == CoapOptionUriPath {
+			parts = append(parts, string(option.Value))
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// Bytes encodes the CoAP message, options must be sorted by option number
+func (m *coapMessage) Bytes() []byte {
+	data := []byte{
+		byte(coapVersion<<6 | (m.Type&0x03)<<4 | len(m.Token)&0x0F),
+		byte(m.Code),
+		byte(m.MessageId >> 8),
+		byte(m.MessageId),
+	}
+	data = append(data, m.Token...)
+
+	optionNumber := 0
+	for _, option := range m.Options {
+		delta := option.Number - optionNumber
+		optionNumber = option.Number
+
+		deltaNibble, deltaExt := encodeCoapOptionValue(delta)
+		lengthNibble, lengthExt := encodeCoapOptionValue(len(option.Value))
+		data = append(data, byte(deltaNibble<<4|lengthNibble))
+		data = append(data, deltaExt...)
+		data = append(data, lengthExt...)
+		data = append(data, option.Value...)
+	}
+
+	if len(m.Payload) > 0 {
+		data = append(data, coapPayloadMarker)
+		data = append(data, m.Payload...)
+	}
+
+	return data
+}
+
+func encodeCoapOptionValue(value int) (int, []byte) {
+	switch {
+	case value < 13:
+		return value, nil
+	case value < 269:
+		return 13, []byte{byte(value - 13)}
+	default:
+		ext := make([]byte, 2)
+		binary.BigEndian.PutUint16(ext, uint16(value-269)) // #nosec G115 -- option numbers are always below 65804
+		return 14, ext
+	}
+}
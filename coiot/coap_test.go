@@ -0,0 +1,146 @@
+package coiot
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+const (
+	// CoIoT header of a Shelly Plug S (non confirmable, code 0.30, uri /cit/s, device id SHPLG-S#6A6250#2, validity, serial)
+	testStatusHeader      = "501e1234b36369740173ed0bec035348504c472d53233641363235302332d2430026820001ff"
+	testDescriptionHeader = "501e1234b36369740164ed0bec035348504c472d53233641363235302332d2430026820001ff"
+
+	testStatusPayload      = `{"G":[[0,4102,1],[0,4101,12.5]]}`
+	testDescriptionPayload = `{"blk":[{"I":1,"D":"relay_0"}],"sen":[{"I":4101,"T":"P","D":"power","U":"W","L":1},{"I":4102,"T":"S","D":"output","R":"0/1","L":1}]}`
+)
+
+func testPacket(t *testing.T, header, payload string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(data, payload...)
+}
+
+func TestParseCoapMessage(t *testing.T) {
+	status := testPacket(t, testStatusHeader, testStatusPayload)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		wantErr  bool
+		uriPath  string
+		deviceId string
+		payload  string
+	}{
+		{name: "status", data: status, uriPath: "/cit/s", deviceId: "SHPLG-S#6A6250#2", payload: testStatusPayload},
+		{name: "description", data: testPacket(t, testDescriptionHeader, testDescriptionPayload), uriPath: "/cit/d", deviceId: "SHPLG-S#6A6250#2", payload: testDescriptionPayload},
+		{name: "too short", data: status[:3], wantErr: true},
+		{name: "invalid version", data: append([]byte{0x90}, status[1:]...), wantErr: true},
+		{name: "invalid token length", data: []byte{0x59, 0x1e, 0x12, 0x34}, wantErr: true},
+		{name: "truncated extended option delta", data: status[:12], wantErr: true},
+		{name: "truncated option value", data: status[:15], wantErr: true},
+		{name: "bad option delta", data: []byte{0x50, 0x1e, 0x12, 0x34, 0xF1, 0x00}, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := parseCoapMessage(test.data)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got message %+v", msg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if msg.Type != CoapTypeNonConfirmable || msg.Code != 30 || msg.MessageId != 0x1234 {
+				t.Errorf("unexpected header: type=%v code=%v messageId=%x", msg.Type, msg.Code, msg.MessageId)
+			}
+			if path := msg.UriPath(); path != test.uriPath {
+				t.Errorf("expected uri path %v, got %v", test.uriPath, path)
+			}
+			if val, ok := msg.Option(CoapOptionShellyDeviceId); !ok || string(val) != test.deviceId {
+				t.Errorf("expected device id %v, got %v", test.deviceId, string(val))
+			}
+			if string(msg.Payload) != test.payload {
+				t.Errorf("expected payload %v, got %v", test.payload, string(msg.Payload))
+			}
+		})
+	}
+}
+
+func TestCoapMessageBytes(t *testing.T) {
+	data := testPacket(t, testStatusHeader, testStatusPayload)
+
+	msg, err := parseCoapMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if encoded := msg.Bytes(); string(encoded) != string(data) {
+		t.Errorf("expected %x, got %x", data, encoded)
+	}
+}
+
+func TestListener(t *testing.T) {
+	store := shellystate.NewStore(time.Minute)
+	listener := NewListener(slogger.NewDiscardLogger(), store)
+	if err := listener.Listen("127.0.0.1:0", ""); err != nil {
+		t.Fatal(err)
+	}
+	go listener.Run()
+	defer listener.Close() // nolint:errcheck
+
+	conn, err := net.DialUDP("udp4", nil, listener.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close() // nolint:errcheck
+
+	// status is only decoded after the description was received
+	for _, packet := range [][]byte{
+		testPacket(t, testDescriptionHeader, testDescriptionPayload),
+		testPacket(t, testStatusHeader, testStatusPayload),
+	} {
+		if _, err := conn.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	var device shellystate.Device
+	for range 20 {
+		var ok bool
+		if device, ok = store.GetDevice("shplg-s-6a6250"); ok && len(device.Values) == 2 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if device.Model != "SHPLG-S" || device.Source != shellystate.SourceCoIoT {
+		t.Fatalf("unexpected device: %+v", device)
+	}
+
+	power, output := false, false
+	for _, value := range device.Values {
+		switch {
+		case value.Block == "relay_0" && value.Name == "power" && value.Value == 12.5:
+			power = true
+		case value.Block == "relay_0" && value.Name == "output" && value.Value == 1:
+			output = true
+		}
+	}
+	if !power || !output {
+		t.Errorf("unexpected values: %+v", device.Values)
+	}
+}
@@ -0,0 +1,280 @@
+package coiot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+const (
+	DefaultAddress = "224.0.1.187:5683"
+	DefaultPort    = 5683
+
+	maxPacketSize = 4096
+
+	// minimal time between description requests for the same device
+	descriptionRequestInterval = 1 * time.Minute
+)
+
+type (
+	// Listener receives CoIoT (CoAP) status messages from gen1 devices
+	Listener struct {
+		logger *slogger.Logger
+		store  *shellystate.Store
+		conn   *net.UDPConn
+
+		lock         sync.RWMutex
+		descriptions map[string]*description
+		addresses    map[string]string
+		requests     map[string]time.Time
+	}
+
+	description struct {
+		Blocks []struct {
+			I int    `json:"I"`
+			D string `json:"D"`
+		} `json:"blk"`
+		Sensors []struct {
+			I int         `json:"I"`
+			T string      `json:"T"`
+			D string      `json:"D"`
+			U string      `json:"U"`
+			L interface{} `json:"L"`
+		} `json:"sen"`
+	}
+
+	status struct {
+		G [][]interface{} `json:"G"`
+	}
+)
+
+func NewListener(logger *slogger.Logger, store *shellystate.Store) *Listener {
+	return &Listener{
+		logger:       logger,
+		store:        store,
+		descriptions: map[string]*description{},
+		addresses:    map[string]string{},
+		requests:     map[string]time.Time{},
+	}
+}
+
+// Listen opens the udp socket, multicast addresses are joined on the interface (or the system default interface)
+func (l *Listener) Listen(address string, interfaceName string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return err
+	}
+
+	if udpAddr.IP.IsMulticast() {
+		var iface *net.Interface
+		if interfaceName != "" {
+			if iface, err = net.InterfaceByName(interfaceName); err != nil {
+				return err
+			}
+		}
+		l.conn, err = net.ListenMulticastUDP("udp4", iface, udpAddr)
+	} else {
+		l.conn, err = net.ListenUDP("udp4", udpAddr)
+	}
+
+	return err
+}
+
+// Run processes incoming packets until the socket is closed
+func (l *Listener) Run() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		size, remoteAddr, err := l.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.logger.Error("failed to read CoIoT packet", slog.Any("error", err))
+			continue
+		}
+
+		packet := make([]byte, size)
+		copy(packet, buf[:size])
+		if err := l.HandlePacket(packet, remoteAddr); err != nil {
+			l.logger.Debug("ignoring CoIoT packet", slog.String("address", remoteAddr.String()), slog.Any("error", err))
+		}
+	}
+}
+
+func (l *Listener) Close() error {
+	return l.conn.Close()
+}
+
+// HandlePacket decodes a CoIoT description (/cit/d) or status (/cit/s) packet
+func (l *Listener) HandlePacket(data []byte, remoteAddr *net.UDPAddr) error {
+	msg, err := parseCoapMessage(data)
+	if err != nil {
+		return err
+	}
+
+	address := remoteAddr.IP.String()
+
+	deviceId, deviceModel := "", ""
+	if val, ok := msg.Option(CoapOptionShellyDeviceId); ok {
+		// format: <model>#<id>#<coiot version>, eg. SHPLG-S#6A6250#2
+		parts := strings.Split(string(val), "#")
+		if len(parts) >= 2 {
+			deviceModel = parts[0]
+			deviceId = strings.ToLower(fmt.Sprintf("%s-%s", parts[0], parts[1]))
+		}
+	}
+
+	l.lock.Lock()
+	if deviceId != "" {
+		l.addresses[address] = deviceId
+	} else {
+		// responses could be sent without device id
+		deviceId = l.addresses[address]
+	}
+	l.lock.Unlock()
+
+	if deviceId == "" {
+		return errors.New(`unknown device, device id option is missing`)
+	}
+
+	switch {
+	case msg.UriPath() == "/cit/d", strings.Contains(string(msg.Payload), `"blk"`):
+		desc := description{}
+		if err := json.Unmarshal(msg.Payload, &desc); err != nil {
+			return fmt.Errorf(`failed to decode description: %w`, err)
+		}
+
+		l.lock.Lock()
+		l.descriptions[deviceId] = &desc
+		l.lock.Unlock()
+
+		l.logger.Debug("received CoIoT description", slog.String("device", deviceId), slog.String("address", address))
+	case msg.UriPath() == "/cit/s", strings.Contains(string(msg.Payload), `"G"`):
+		data := status{}
+		if err := json.Unmarshal(msg.Payload, &data); err != nil {
+			return fmt.Errorf(`failed to decode status: %w`, err)
+		}
+
+		l.lock.RLock()
+		desc := l.descriptions[deviceId]
+		l.lock.RUnlock()
+
+		if desc == nil {
+			// status can only be decoded with the description
+			l.requestDescription(deviceId, remoteAddr)
+			return nil
+		}
+
+		values := desc.decodeStatus(data)
+		l.store.ApplyValues(deviceId, 1, shellystate.SourceCoIoT, address, values)
+		if deviceModel != "" {
+			l.store.UpdateDevice(deviceId, func(device *shellystate.Device) {
+				device.Model = deviceModel
+			})
+		}
+
+		l.logger.Debug("received CoIoT status", slog.String("device", deviceId), slog.String("address", address), slog.Int("values", len(values)))
+	default:
+		return fmt.Errorf(`unsupported message %v`, msg.UriPath())
+	}
+
+	return nil
+}
+
+// requestDescription sends a CoAP GET /cit/d request to the device
+func (l *Listener) requestDescription(deviceId string, remoteAddr *net.UDPAddr) {
+	l.lock.Lock()
+	if lastRequest, exists := l.requests[deviceId]; exists && time.Since(lastRequest) < descriptionRequestInterval {
+		l.lock.Unlock()
+		return
+	}
+	l.requests[deviceId] = time.Now()
+	l.lock.Unlock()
+
+	request := coapMessage{
+		Type:      CoapTypeNonConfirmable,
+		Code:      CoapCodeGet,
+		MessageId: uint16(rand.IntN(65536)), // #nosec G404 G115 -- message id only used for deduplication
+		Options: []coapOption{
+			{Number: CoapOptionUriPath, Value: []byte("cit")},
+			{Number: CoapOptionUriPath, Value: []byte("d")},
+		},
+	}
+
+	// devices are listening on the CoIoT port
+	targetAddr := &net.UDPAddr{IP: remoteAddr.IP, Port: DefaultPort}
+
+	l.logger.Debug("requesting CoIoT description", slog.String("device", deviceId), slog.String("address", targetAddr.String()))
+	if _, err := l.conn.WriteToUDP(request.Bytes(), targetAddr); err != nil {
+		l.logger.Warn("failed to request CoIoT description", slog.String("device", deviceId), slog.Any("error", err))
+	}
+}
+
+// decodeStatus converts the status values (G) to sensor values using the description
+func (d *description) decodeStatus(data status) []shellystate.Value {
+	blocks := map[int]string{}
+	for _, block := range d.Blocks {
+		blocks[block.I] = block.D
+	}
+
+	ret := []shellystate.Value{}
+	for _, row := range data.G {
+		// format: [channel, sensor id, value]
+		if len(row) < 3 {
+			continue
+		}
+
+		sensorId, ok := row[1].(float64)
+		if !ok {
+			continue
+		}
+
+		value, ok := row[2].(float64)
+		if !ok {
+			// non numeric values (eg. roller state) are not supported
+			continue
+		}
+
+		for _, sensor := range d.Sensors {
+			if sensor.I != int(sensorId) {
+				continue
+			}
+
+			ret = append(ret, shellystate.Value{
+				Block: blocks[sensorBlockId(sensor.L)],
+				Type:  sensor.T,
+				Name:  sensor.D,
+				Unit:  sensor.U,
+				Value: value,
+			})
+			break
+		}
+	}
+
+	return ret
+}
+
+// sensorBlockId returns the block of the sensor, sensors assigned to multiple blocks are using the first block
+func sensorBlockId(val interface{}) int {
+	switch v := val.(type) {
+	case float64:
+		return int(v)
+	case []interface{}:
+		if len(v) > 0 {
+			if id, ok := v[0].(float64); ok {
+				return int(id)
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	yaml "go.yaml.in/yaml/v3"
+
+	"github.com/webdevops/shelly-plug-exporter/credentials"
+)
+
+var (
+	labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	// reservedLabelNames are used by the exporter and cannot be used as custom labels
+	reservedLabelNames = []string{"target", "mac", "hostname", "plugName", "plugModel", "plugApp", "plugGeneration"}
+)
+
+type (
+	// ConfigFile is the configuration file (yaml or json), flags are overriding the settings of the config file
+	ConfigFile struct {
+		Defaults    ConfigFileDefaults       `yaml:"defaults"`
+		Discovery   ConfigFileDiscovery      `yaml:"discovery"`
+		Credentials []credentials.Credential `yaml:"credentials"`
+		Devices     []ConfigFileDevice       `yaml:"devices"`
+	}
+
+	ConfigFileDefaults struct {
+		Request struct {
+			Timeout          *time.Duration `yaml:"timeout"`
+			RetryCount       *int           `yaml:"retryCount"`
+			RetryWaitTime    *time.Duration `yaml:"retryWaitTime"`
+			RetryWaitTimeMax *time.Duration `yaml:"retryWaitTimeMax"`
+		} `yaml:"request"`
+
+		Auth   *ConfigFileAuth   `yaml:"auth"`
+		Labels map[string]string `yaml:"labels"`
+	}
+
+	ConfigFileDiscovery struct {
+		Enabled *bool          `yaml:"enabled"`
+		Timeout *time.Duration `yaml:"timeout"`
+		Refresh *time.Duration `yaml:"refresh"`
+
+		// Include and Exclude are glob patterns for hostname or address of discovered devices
+		Include []string `yaml:"include"`
+		Exclude []string `yaml:"exclude"`
+	}
+
+	ConfigFileDevice struct {
+		Host         string            `yaml:"host"`
+		Type         string            `yaml:"type"`
+		Generation   int               `yaml:"generation"`
+		Enabled      *bool             `yaml:"enabled"`
+		Timeout      time.Duration     `yaml:"timeout"`
+		PollInterval time.Duration     `yaml:"pollInterval"`
+		Auth         *ConfigFileAuth   `yaml:"auth"`
+		Labels       map[string]string `yaml:"labels"`
+	}
+
+	ConfigFileAuth struct {
+		Username     string `yaml:"username"`
+		Password     string `yaml:"password"`
+		PasswordFile string `yaml:"passwordFile"`
+	}
+)
+
+// LoadConfigFile loads the config file, json files are parsed as yaml (yaml is a superset of json)
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path is passed by the user
+	if err != nil {
+		return nil, err
+	}
+
+	ret := ConfigFile{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&ret); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf(`failed to parse config file "%v": %w`, path, err)
+	}
+
+	if err := ret.validate(); err != nil {
+		return nil, fmt.Errorf(`invalid config file "%v": %w`, path, err)
+	}
+
+	return &ret, nil
+}
+
+func (c *ConfigFile) validate() error {
+	if err := validateLabels(c.Defaults.Labels); err != nil {
+		return err
+	}
+
+	for num, credential := range c.Credentials {
+		if credential.Name == "" {
+			return fmt.Errorf(`credentials #%d: name is missing`, num+1)
+		}
+	}
+
+	for num, device := range c.Devices {
+		if device.Host == "" {
+			return fmt.Errorf(`device #%d: host is missing`, num+1)
+		}
+
+		if device.Generation < 0 || device.Generation > 4 {
+			return fmt.Errorf(`device "%v": invalid generation %d`, device.Host, device.Generation)
+		}
+
+		if err := validateLabels(device.Labels); err != nil {
+			return fmt.Errorf(`device "%v": %w`, device.Host, err)
+		}
+	}
+
+	return nil
+}
+
+// LabelNames returns all custom label names (defaults and devices)
+func (c *ConfigFile) LabelNames() []string {
+	ret := []string{}
+	for name := range c.Defaults.Labels {
+		ret = append(ret, name)
+	}
+
+	for _, device := range c.Devices {
+		for name := range device.Labels {
+			ret = append(ret, name)
+		}
+	}
+
+	slices.Sort(ret)
+	return slices.Compact(ret)
+}
+
+// IsEnabled returns true if the device is not disabled
+func (d *ConfigFileDevice) IsEnabled() bool {
+	return d.Enabled == nil || *d.Enabled
+}
+
+func validateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelNameRegexp.MatchString(name) {
+			return fmt.Errorf(`invalid label name "%v"`, name)
+		}
+
+		// labels prefixed with __ are reserved by Prometheus
+		if slices.Contains(reservedLabelNames, name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf(`label name "%v" is reserved`, name)
+		}
+	}
+	return nil
+}
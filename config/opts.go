@@ -7,6 +7,9 @@ import (
 
 type (
 	Opts struct {
+		// config file
+		ConfigFile string `long:"config"  env:"CONFIG"  description:"Path to config file (yaml or json) with devices, discovery settings and defaults, flags are overriding the config file"`
+
 		// logger
 		Logger struct {
 			Level  string `long:"log.level"    env:"LOG_LEVEL"   description:"Log level" choice:"trace" choice:"debug" choice:"info" choice:"warning" choice:"error" default:"info"`                          // nolint:staticcheck // multiple choices are ok
@@ -17,16 +20,26 @@ type (
 		}
 
 		Shelly struct {
+			TargetLabel string `long:"shelly.targetlabel"  env:"SHELLY_TARGETLABEL"  description:"Value of the target label (deviceid: mac address of the device, address until the device is probed)" choice:"address" choice:"deviceid" default:"address"` // nolint:staticcheck // multiple choices are ok
+
 			Request struct {
 				Timeout          time.Duration `long:"shelly.request.timeout"            env:"SHELLY_REQUEST_TIMEOUT"            description:"Request timeout" default:"2s"`
 				RetryCount       int           `long:"shelly.request.retry.count"        env:"SHELLY_REQUEST_RETRY_COUNT"        description:"Retry count for failing requests" default:"3"`
 				RetryWaitTime    time.Duration `long:"shelly.request.retry.waittime"     env:"SHELLY_REQUEST_RETRY_WAITTIME"     description:"Wait time after retry" default:"100ms"`
 				RetryWaitTimeMax time.Duration `long:"shelly.request.retry.waittimemax"  env:"SHELLY_REQUEST_RETRY_WAITTIMEMAX"  description:"Maximum wait time after retry" default:"1s"`
+				TimeoutOffset    time.Duration `long:"shelly.request.timeoutoffset"      env:"SHELLY_REQUEST_TIMEOUTOFFSET"      description:"Offset subtracted from the Prometheus scrape timeout (time for sending the response)" default:"500ms"`
+
+				Concurrency struct {
+					Max       int `long:"shelly.request.concurrency.max"        env:"SHELLY_REQUEST_CONCURRENCY_MAX"        description:"Maximum number of devices probed at once (0 = unlimited)" default:"20"`
+					PerDevice int `long:"shelly.request.concurrency.perdevice"  env:"SHELLY_REQUEST_CONCURRENCY_PERDEVICE"  description:"Maximum number of in-flight requests per device (0 = unlimited)" default:"1"`
+				}
 			}
 
 			Auth struct {
-				Username string `long:"shelly.auth.username"  env:"SHELLY_AUTH_USERNAME"  description:"Username for shelly plug login"`
-				Password string `long:"shelly.auth.password"  env:"SHELLY_AUTH_PASSWORD"  description:"Password for shelly plug login" json:"-"`
+				Username     string `long:"shelly.auth.username"      env:"SHELLY_AUTH_USERNAME"      description:"Username for shelly plug login"`
+				Password     string `long:"shelly.auth.password"      env:"SHELLY_AUTH_PASSWORD"      description:"Password for shelly plug login" json:"-"`
+				PasswordFile string `long:"shelly.auth.passwordfile"  env:"SHELLY_AUTH_PASSWORDFILE"  description:"Password file for shelly plug login (eg. Docker/Kubernetes secrets)"`
+				AdHoc        bool   `long:"shelly.auth.adhoc"         env:"SHELLY_AUTH_ADHOC"         description:"Use all credentials for /probe?target= hosts which are not discovered or configured (otherwise only credential sets matching the address by CIDR range)"`
 			}
 
 			Host struct {
@@ -35,6 +48,53 @@ type (
 				ShellyPro  []string `long:"shelly.host.shellypro"   env:"SHELLY_HOST_SHELLYPROS"   env-delim:","  description:"shellypro device IP or hostname to scrape. Pass multiple times for multiple hosts" default:""`
 			}
 
+			Push struct {
+				Enabled    bool          `long:"shelly.push.enable"      env:"SHELLY_PUSH_ENABLE"      description:"Enable push endpoint (/push) for sleeping battery devices"`
+				Retention  time.Duration `long:"shelly.push.retention"   env:"SHELLY_PUSH_RETENTION"   description:"Retention time of pushed device values" default:"24h"`
+				Token      string        `long:"shelly.push.token"       env:"SHELLY_PUSH_TOKEN"       description:"Token for push endpoint (passed as ?token=xxx or bearer token)" json:"-"`
+				MaxDevices int           `long:"shelly.push.maxdevices"  env:"SHELLY_PUSH_MAXDEVICES"  description:"Maximum number of pushed devices, pushes of new devices are rejected afterwards (0 = unlimited)" default:"100"`
+			}
+
+			CoIoT struct {
+				Enabled   bool          `long:"shelly.coiot.enable"     env:"SHELLY_COIOT_ENABLE"     description:"Enable CoIoT (CoAP) listener for gen1 devices"`
+				Bind      string        `long:"shelly.coiot.bind"       env:"SHELLY_COIOT_BIND"       description:"CoIoT listen address (multicast group or unicast address)" default:"224.0.1.187:5683"`
+				Interface string        `long:"shelly.coiot.interface"  env:"SHELLY_COIOT_INTERFACE"  description:"Network interface for CoIoT multicast (default: system default)"`
+				Retention time.Duration `long:"shelly.coiot.retention"  env:"SHELLY_COIOT_RETENTION"  description:"Retention time of CoIoT device values" default:"10m"`
+			}
+
+			MQTT struct {
+				Enabled   bool          `long:"shelly.mqtt.enable"     env:"SHELLY_MQTT_ENABLE"     description:"Enable MQTT subscriber for devices publishing to a MQTT broker"`
+				Broker    string        `long:"shelly.mqtt.broker"     env:"SHELLY_MQTT_BROKER"     description:"MQTT broker url" default:"tcp://localhost:1883"`
+				ClientId  string        `long:"shelly.mqtt.clientid"   env:"SHELLY_MQTT_CLIENTID"   description:"MQTT client id" default:"shelly-plug-exporter"`
+				Username  string        `long:"shelly.mqtt.username"   env:"SHELLY_MQTT_USERNAME"   description:"MQTT username"`
+				Password  string        `long:"shelly.mqtt.password"   env:"SHELLY_MQTT_PASSWORD"   description:"MQTT password" json:"-"`
+				Topics    []string      `long:"shelly.mqtt.topic"      env:"SHELLY_MQTT_TOPICS"     env-delim:"," description:"MQTT topics to subscribe (gen1: shellies/#; gen2: <prefix>/status/+, <prefix>/events/rpc, <prefix>/online)" default:"shellies/#" default:"+/status/+" default:"+/events/rpc" default:"+/online"` // nolint:staticcheck // multiple defaults are ok
+				Retention time.Duration `long:"shelly.mqtt.retention"  env:"SHELLY_MQTT_RETENTION"  description:"Retention time of MQTT device values" default:"1h"`
+			}
+
+			Websocket struct {
+				Enabled bool          `long:"shelly.websocket.enable"  env:"SHELLY_WEBSOCKET_ENABLE"  description:"Enable persistent websocket connections to gen2+ devices (status mirrored via notifications)"`
+				Resync  time.Duration `long:"shelly.websocket.resync"  env:"SHELLY_WEBSOCKET_RESYNC"  description:"Interval for full status resync of websocket connections" default:"5m"`
+
+				Server struct {
+					Enabled    bool   `long:"shelly.websocket.server.enable"      env:"SHELLY_WEBSOCKET_SERVER_ENABLE"      description:"Enable websocket endpoint (/websocket) for devices with configured outbound websocket"`
+					Token      string `long:"shelly.websocket.server.token"       env:"SHELLY_WEBSOCKET_SERVER_TOKEN"       description:"Token for websocket endpoint (passed as ?token=xxx or bearer token)" json:"-"`
+					MaxDevices int    `long:"shelly.websocket.server.maxdevices"  env:"SHELLY_WEBSOCKET_SERVER_MAXDEVICES"  description:"Maximum number of devices connected to the websocket endpoint (0 = unlimited)" default:"100"`
+				}
+			}
+
+			CircuitBreaker struct {
+				Threshold   int           `long:"shelly.circuitbreaker.threshold"    env:"SHELLY_CIRCUITBREAKER_THRESHOLD"    description:"Consecutive failed probes until the circuit breaker of a device opens (0 = disabled)" default:"5"`
+				Cooldown    time.Duration `long:"shelly.circuitbreaker.cooldown"     env:"SHELLY_CIRCUITBREAKER_COOLDOWN"     description:"Time until a device with open circuit breaker is probed again (doubled after every failed probe)" default:"1m"`
+				CooldownMax time.Duration `long:"shelly.circuitbreaker.cooldownmax"  env:"SHELLY_CIRCUITBREAKER_COOLDOWNMAX"  description:"Maximum time until a device with open circuit breaker is probed again" default:"15m"`
+			}
+
+			Polling struct {
+				Enabled  bool          `long:"shelly.polling.enable"    env:"SHELLY_POLLING_ENABLE"    description:"Enable background polling of all targets, /probe and /metrics are served from the last snapshot"`
+				Interval time.Duration `long:"shelly.polling.interval"  env:"SHELLY_POLLING_INTERVAL"  description:"Polling interval per target" default:"30s"`
+				MaxAge   time.Duration `long:"shelly.polling.maxage"    env:"SHELLY_POLLING_MAXAGE"    description:"Maximum age of the last good snapshot, failed polls are reported afterwards" default:"5m"`
+			}
+
 			ServiceDiscovery struct {
 				Timeout time.Duration `long:"shelly.servicediscovery.timeout"  env:"SHELLY_SERVICEDISCOVERY_TIMEOUT"  description:"mDNS discovery response timeout" default:"15s"`
 				Refresh time.Duration `long:"shelly.servicediscovery.refresh"  env:"SHELLY_SERVICEDISCOVERY_REFRESH"  description:"mDNS discovery refresh time" default:"15m"`
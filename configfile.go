@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/webdevops/shelly-plug-exporter/config"
+	"github.com/webdevops/shelly-plug-exporter/credentials"
+	"github.com/webdevops/shelly-plug-exporter/discovery"
+	"github.com/webdevops/shelly-plug-exporter/shellyplug"
+)
+
+var (
+	configFile *config.ConfigFile
+)
+
+// initConfigFile loads the config file and applies the defaults, flags (and env vars) are overriding the config file
+func initConfigFile() {
+	if Opts.ConfigFile == "" {
+		return
+	}
+
+	var err error
+	configFile, err = config.LoadConfigFile(Opts.ConfigFile)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	defaults := configFile.Defaults
+	if defaults.Request.Timeout != nil && !isOptionSet("shelly.request.timeout") {
+		Opts.Shelly.Request.Timeout = *defaults.Request.Timeout
+	}
+	if defaults.Request.RetryCount != nil && !isOptionSet("shelly.request.retry.count") {
+		Opts.Shelly.Request.RetryCount = *defaults.Request.RetryCount
+	}
+	if defaults.Request.RetryWaitTime != nil && !isOptionSet("shelly.request.retry.waittime") {
+		Opts.Shelly.Request.RetryWaitTime = *defaults.Request.RetryWaitTime
+	}
+	if defaults.Request.RetryWaitTimeMax != nil && !isOptionSet("shelly.request.retry.waittimemax") {
+		Opts.Shelly.Request.RetryWaitTimeMax = *defaults.Request.RetryWaitTimeMax
+	}
+
+	if defaults.Auth != nil && !isOptionSet("shelly.auth.username") && !isOptionSet("shelly.auth.password") && !isOptionSet("shelly.auth.passwordfile") {
+		Opts.Shelly.Auth.Username = defaults.Auth.Username
+		Opts.Shelly.Auth.Password = defaults.Auth.Password
+		Opts.Shelly.Auth.PasswordFile = defaults.Auth.PasswordFile
+	}
+
+	if configFile.Discovery.Timeout != nil && !isOptionSet("shelly.servicediscovery.timeout") {
+		Opts.Shelly.ServiceDiscovery.Timeout = *configFile.Discovery.Timeout
+	}
+	if configFile.Discovery.Refresh != nil && !isOptionSet("shelly.servicediscovery.refresh") {
+		Opts.Shelly.ServiceDiscovery.Refresh = *configFile.Discovery.Refresh
+	}
+
+	shellyplug.SetCustomLabelNames(configFile.LabelNames())
+}
+
+// isOptionSet returns true if the option was passed as flag or env var
+func isOptionSet(name string) bool {
+	option := argparser.FindOptionByLongName(name)
+	if option == nil {
+		return false
+	}
+
+	// options set by env var are also marked as default
+	if envKey := option.EnvKeyWithNamespace(); envKey != "" {
+		if _, exists := os.LookupEnv(envKey); exists {
+			return true
+		}
+	}
+
+	return option.IsSet() && !option.IsSetDefault()
+}
+
+// buildDiscoverySettings builds the static targets and discovery filters from the config file
+func buildDiscoverySettings() discovery.Settings {
+	settings := discovery.Settings{}
+	if configFile == nil {
+		return settings
+	}
+
+	settings.Labels = configFile.Defaults.Labels
+	settings.Include = configFile.Discovery.Include
+	settings.Exclude = configFile.Discovery.Exclude
+	if configFile.Discovery.Enabled != nil {
+		settings.DisableMdns = !*configFile.Discovery.Enabled
+	}
+
+	for _, device := range configFile.Devices {
+		if !device.IsEnabled() {
+			// disabled devices are also excluded from mDNS servicediscovery (filters are matching hostname or address without port)
+			host := device.Host
+			if val, _, err := net.SplitHostPort(host); err == nil {
+				host = val
+			}
+			settings.Exclude = append(settings.Exclude, host)
+			continue
+		}
+
+		deviceType := device.Type
+		if deviceType == "" {
+			deviceType = discovery.TargetTypeShellyPlug
+			if device.Generation >= 2 {
+				deviceType = discovery.TargetTypeShellyPro
+			}
+		}
+
+		target, err := discovery.ParseTarget(device.Host, deviceType)
+		if err != nil {
+			logger.Fatal(fmt.Sprintf(`invalid device "%v" in config file: %v`, device.Host, err))
+		}
+
+		if device.Generation > 0 {
+			target.Generation = strconv.Itoa(device.Generation)
+		}
+		target.Timeout = device.Timeout
+		target.PollInterval = device.PollInterval
+		if device.Auth != nil {
+			target.Auth = &credentials.Credential{
+				Name:         fmt.Sprintf("device %v", device.Host),
+				Username:     device.Auth.Username,
+				Password:     device.Auth.Password,
+				PasswordFile: device.Auth.PasswordFile,
+			}
+		}
+
+		target.Labels = map[string]string{}
+		maps.Copy(target.Labels, configFile.Defaults.Labels)
+		maps.Copy(target.Labels, device.Labels)
+
+		settings.Targets = append(settings.Targets, target)
+	}
+
+	return settings
+}
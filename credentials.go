@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/webdevops/shelly-plug-exporter/credentials"
+)
+
+var (
+	credentialStore *credentials.Store
+)
+
+// initCredentials builds the credential store from the config file, the global credentials are used as fallback
+func initCredentials() {
+	list := []credentials.Credential{}
+	if configFile != nil {
+		list = configFile.Credentials
+	}
+
+	fallback := &credentials.Credential{
+		Name:         "global",
+		Username:     Opts.Shelly.Auth.Username,
+		Password:     Opts.Shelly.Auth.Password,
+		PasswordFile: Opts.Shelly.Auth.PasswordFile,
+	}
+	if fallback.IsEmpty() {
+		fallback = nil
+	}
+
+	credentialStore = credentials.NewStore(list, fallback)
+}
@@ -0,0 +1,166 @@
+package credentials
+
+import (
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	macRegexp = regexp.MustCompile(`^[0-9A-F]{12}$`)
+)
+
+type (
+	// Store contains the credential sets, the first matching credential set is used
+	Store struct {
+		list     []Credential
+		fallback *Credential
+	}
+
+	Credential struct {
+		// Name is used for logging which credential set matched
+		Name string `yaml:"name"`
+
+		Username     string `yaml:"username"`
+		Password     string `yaml:"password"     json:"-"`
+		PasswordFile string `yaml:"passwordFile"`
+
+		Match Match `yaml:"match"`
+	}
+
+	// Match contains the device matchers, any matching entry selects the credential set
+	Match struct {
+		// Mac contains mac addresses (eg. A8032AB1B1B0 or a8:03:2a:b1:b1:b0)
+		Mac []string `yaml:"mac"`
+		// Hostname contains glob patterns for the hostname (eg. shellypro*)
+		Hostname []string `yaml:"hostname"`
+		// Address contains glob patterns or CIDR ranges for the address (eg. 192.168.10.* or 192.168.10.0/24)
+		Address []string `yaml:"address"`
+	}
+)
+
+// NewStore creates a credential store, fallback is used if no credential set matches (optional)
+func NewStore(list []Credential, fallback *Credential) *Store {
+	return &Store{
+		list:     list,
+		fallback: fallback,
+	}
+}
+
+// Lookup returns the first matching credential set for the device, nil if no credentials are configured
+func (s *Store) Lookup(hostname, address, mac string) *Credential {
+	if s == nil {
+		return nil
+	}
+
+	if mac == "" {
+		mac = macFromHostname(hostname)
+	}
+
+	for num := range s.list {
+		if s.list[num].Match.matches(hostname, address, mac) {
+			return &s.list[num]
+		}
+	}
+
+	return s.fallback
+}
+
+// LookupNetwork returns the first credential set matching the address by CIDR range, nil if no set matches.
+// Hostname, mac and glob patterns are not used and there is no fallback as these can be controlled by the probed host
+func (s *Store) LookupNetwork(address string) *Credential {
+	if s == nil {
+		return nil
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil
+	}
+
+	for num := range s.list {
+		for _, val := range s.list[num].Match.Address {
+			if _, network, err := net.ParseCIDR(val); err == nil && network.Contains(ip) {
+				return &s.list[num]
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetPassword returns the password, password files are read on every call (eg. rotated Kubernetes secrets)
+func (c *Credential) GetPassword() (string, error) {
+	if c.PasswordFile != "" {
+		data, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	return c.Password, nil
+}
+
+// IsEmpty returns true if neither username nor password is set
+func (c *Credential) IsEmpty() bool {
+	return c.Username == "" && c.Password == "" && c.PasswordFile == ""
+}
+
+func (m *Match) matches(hostname, address, mac string) bool {
+	if mac != "" {
+		for _, val := range m.Mac {
+			if normalizeMac(val) == normalizeMac(mac) {
+				return true
+			}
+		}
+	}
+
+	if hostname != "" && matchPatterns(m.Hostname, hostname) {
+		return true
+	}
+
+	if address != "" {
+		if matchPatterns(m.Address, address) {
+			return true
+		}
+
+		if ip := net.ParseIP(address); ip != nil {
+			for _, val := range m.Address {
+				if _, network, err := net.ParseCIDR(val); err == nil && network.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func matchPatterns(patterns []string, val string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(val)); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeMac(val string) string {
+	val = strings.ToUpper(val)
+	val = strings.ReplaceAll(val, ":", "")
+	val = strings.ReplaceAll(val, "-", "")
+	return val
+}
+
+// macFromHostname returns the mac address from the hostname (eg. shellyplus1pm-a8032ab1b1b0)
+func macFromHostname(hostname string) string {
+	if pos := strings.LastIndex(hostname, "-"); pos >= 0 {
+		if mac := normalizeMac(hostname[pos+1:]); macRegexp.MatchString(mac) {
+			return mac
+		}
+	}
+	return ""
+}
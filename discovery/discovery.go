@@ -1,7 +1,9 @@
 package discovery
 
 import (
+	"fmt"
 	"log/slog"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,6 +32,23 @@ type (
 		targetList  map[string]*DiscoveryTarget
 		lock        sync.RWMutex
 		staticHosts []DiscoveryTarget
+		settings    Settings
+	}
+
+	// Settings contains the additional discovery settings (eg. from config file)
+	Settings struct {
+		// Targets are additional static targets
+		Targets []DiscoveryTarget
+
+		// DisableMdns disables the mDNS servicediscovery, only static targets are used
+		DisableMdns bool
+
+		// Include and Exclude are filtering the mDNS discovered targets (glob patterns for hostname or address)
+		Include []string
+		Exclude []string
+
+		// Labels are the default custom labels for discovered targets
+		Labels map[string]string
 	}
 
 	serviceDiscoveryTarget struct {
@@ -50,9 +69,10 @@ var (
 	ServiceDiscovery *serviceDiscovery
 )
 
-func EnableDiscovery(logger *slogger.Logger, refreshTime time.Duration, timeout time.Duration, shellyplugs []string, shellyplus []string, shellypro []string) {
+func EnableDiscovery(logger *slogger.Logger, refreshTime time.Duration, timeout time.Duration, shellyplugs []string, shellyplus []string, shellypro []string, settings Settings) {
 	ServiceDiscovery = &serviceDiscovery{}
 	ServiceDiscovery.logger = logger
+	ServiceDiscovery.settings = settings
 	ServiceDiscovery.init(shellyplugs, shellyplus, shellypro)
 
 	go func() {
@@ -81,9 +101,38 @@ func (d *serviceDiscovery) init(shellyplugs []string, shellyplus []string, shell
 			staticHosts = append(staticHosts, discoveryTargetFromStatic(entry, TargetTypeShellyPro))
 		}
 	}
+	for num := range staticHosts {
+		staticHosts[num].Labels = d.settings.Labels
+	}
+
+	staticHosts = append(staticHosts, d.settings.Targets...)
 	d.staticHosts = staticHosts
 }
 
+// isFiltered returns true if the discovered target is excluded by the include/exclude filters
+func (d *serviceDiscovery) isFiltered(target *DiscoveryTarget) bool {
+	if matchTargetPatterns(target, d.settings.Exclude) {
+		return true
+	}
+
+	if len(d.settings.Include) > 0 && !matchTargetPatterns(target, d.settings.Include) {
+		return true
+	}
+
+	return false
+}
+
+func matchTargetPatterns(target *DiscoveryTarget, patterns []string) bool {
+	for _, pattern := range patterns {
+		for _, val := range []string{target.Hostname, target.Address} {
+			if matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(val)); err == nil && matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (d *serviceDiscovery) Run(timeout time.Duration) {
 	var targetList []DiscoveryTarget
 	targetList = append(targetList, d.staticHosts...)
@@ -93,13 +142,41 @@ func (d *serviceDiscovery) Run(timeout time.Duration) {
 	targetChannel := make(chan *DiscoveryTarget, 1)
 
 	wg.Add(1)
+	staticAddresses := map[string]bool{}
+	for _, target := range d.staticHosts {
+		staticAddresses[target.Address] = true
+	}
+	staticDeviceIds := d.staticDeviceIds()
+
 	go func() {
 		defer wg.Done()
 		for target := range targetChannel {
+			target.DeviceId = deviceIdFromHostname(target.Hostname)
+
+			// static targets have precedence (might contain settings from config file)
+			if staticAddresses[target.Address] || staticDeviceIds[target.DeviceId] {
+				continue
+			}
+
+			if d.isFiltered(target) {
+				d.logger.Debug(`ignoring filtered target`, slog.String("target", target.Name()))
+				continue
+			}
+
+			if target.Labels == nil {
+				target.Labels = d.settings.Labels
+			}
 			targetList = append(targetList, *target)
 		}
 	}()
 
+	if d.settings.DisableMdns {
+		close(targetChannel)
+		wg.Wait()
+		d.updateTargetList(targetList)
+		return
+	}
+
 	// mDNS discovery via _http._tcp.
 	d.discover("_http._tcp", timeout, func(logger *slogger.Logger, target *serviceDiscoveryTarget) *DiscoveryTarget {
 		switch {
@@ -137,7 +214,7 @@ func (d *serviceDiscovery) Run(timeout time.Duration) {
 
 		if gen, ok := target.InfoFields["gen"]; ok {
 			switch strings.ToLower(gen) {
-			case "2":
+			case "2", "3", "4":
 				return &DiscoveryTarget{
 					Hostname:   target.Name,
 					Port:       target.Port,
@@ -156,7 +233,7 @@ func (d *serviceDiscovery) Run(timeout time.Duration) {
 	d.discover("_shelly._tcp", timeout, func(logger *slogger.Logger, target *serviceDiscoveryTarget) *DiscoveryTarget {
 		if gen, ok := target.InfoFields["gen"]; ok {
 			switch strings.ToLower(gen) {
-			case "2":
+			case "2", "3", "4":
 				return &DiscoveryTarget{
 					Hostname:   target.Name,
 					Port:       target.Port,
@@ -174,6 +251,10 @@ func (d *serviceDiscovery) Run(timeout time.Duration) {
 	close(targetChannel)
 	wg.Wait()
 
+	d.updateTargetList(targetList)
+}
+
+func (d *serviceDiscovery) updateTargetList(targetList []DiscoveryTarget) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
@@ -194,8 +275,37 @@ func (d *serviceDiscovery) Run(timeout time.Duration) {
 	// set all discovered targets to good health
 	for _, row := range targetList {
 		target := row
-		d.targetList[target.Address] = &target
-		d.targetList[target.Address].Health = TargetHealthGood
+		if target.DeviceId == "" && !target.Static {
+			// device id might be known from previous probes
+			if existing := d.findTargetByAddress(target.Address); existing != nil {
+				target.DeviceId = existing.DeviceId
+			}
+		}
+
+		key := target.Key()
+		if existing, exists := d.targetList[key]; exists {
+			// keep the device information from previous probes
+			if target.DeviceId == "" {
+				target.DeviceId = existing.DeviceId
+			}
+			if target.DeviceName == nil {
+				target.DeviceName = existing.DeviceName
+			}
+
+			if existing.Address != target.Address {
+				d.logger.Info(`target address changed`, slog.String("target", target.Name()), slog.String("previousAddress", existing.Address))
+			}
+		}
+
+		// remove the target keyed by address if the device id is known now, targets of other devices are kept
+		if key != target.Address {
+			if previous, exists := d.targetList[target.Address]; exists && !previous.Static && previous.Key() == previous.Address {
+				delete(d.targetList, target.Address)
+			}
+		}
+
+		target.Health = TargetHealthGood
+		d.targetList[key] = &target
 	}
 
 	d.logger.Debug(`finished mDNS servicediscovery"`, slog.Int("targets", len(d.targetList)))
@@ -274,38 +384,110 @@ func (d *serviceDiscovery) discover(service string, timeout time.Duration, callb
 	wg.Wait()
 }
 
-func (d *serviceDiscovery) MarkTarget(address string, healthy bool) {
+// MarkTarget marks the target (see DiscoveryTarget.Key) as healthy or unhealthy
+func (d *serviceDiscovery) MarkTarget(key string, healthy bool) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
-	if target, exists := d.targetList[address]; exists {
+	if target, exists := d.targetList[key]; exists {
 		if target.Static {
 			// Assume Static targets are always healthy
 			return
 		}
 		if healthy {
-			d.targetList[address].Health = TargetHealthGood
+			d.targetList[key].Health = TargetHealthGood
 		} else {
-			d.targetList[address].Health = (target.Health - 1)
-		}
-	} else {
-		if healthy {
-			d.targetList[address].Health = TargetHealthGood
+			d.targetList[key].Health = (target.Health - 1)
 		}
 	}
 
 	d.cleanup()
 }
 
-func (d *serviceDiscovery) SetTargetDeviceName(address, deviceName string) {
+func (d *serviceDiscovery) SetTargetDeviceName(key, deviceName string) {
 	d.lock.Lock()
 	defer d.lock.Unlock()
 
-	if _, exists := d.targetList[address]; exists {
-		d.targetList[address].DeviceName = &deviceName
+	if _, exists := d.targetList[key]; exists {
+		d.targetList[key].DeviceName = &deviceName
 	}
 }
 
+// SetTargetDeviceId sets the device id (mac address from /shelly) of the target (see DiscoveryTarget.Key),
+// discovered targets are keyed by the device id afterwards so address changes are updating the existing target
+func (d *serviceDiscovery) SetTargetDeviceId(key, deviceId string) {
+	deviceId = NormalizeDeviceId(deviceId)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	target, exists := d.targetList[key]
+	if !exists || deviceId == "" || target.DeviceId == deviceId {
+		return
+	}
+
+	if target.Static {
+		target.DeviceId = deviceId
+		return
+	}
+
+	newTarget := *target
+	newTarget.DeviceId = deviceId
+	if target.DeviceId == "" {
+		// target was keyed by address until the device id was known
+		delete(d.targetList, key)
+	} else {
+		// another device is using the address now, the address of the target is outdated
+		d.logger.Info(`target address is used by another device`, slog.String("target", target.Name()), slog.String("deviceId", deviceId))
+		target.Health--
+		newTarget.DeviceName = nil
+	}
+
+	if existing, exists := d.targetList[deviceId]; exists {
+		if existing.Address != newTarget.Address {
+			d.logger.Info(`target address changed`, slog.String("target", newTarget.Name()), slog.String("previousAddress", existing.Address))
+			existing.Address = newTarget.Address
+			existing.Port = newTarget.Port
+			existing.Hostname = newTarget.Hostname
+		}
+		existing.Health = TargetHealthGood
+	} else {
+		newTarget.Health = TargetHealthGood
+		d.targetList[deviceId] = &newTarget
+	}
+
+	d.cleanup()
+}
+
+// staticDeviceIds returns the known device ids of the static targets
+func (d *serviceDiscovery) staticDeviceIds() map[string]bool {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	ret := map[string]bool{}
+	for _, target := range d.targetList {
+		if target.Static && target.DeviceId != "" {
+			ret[target.DeviceId] = true
+		}
+	}
+	return ret
+}
+
+// findTargetByAddress returns the discovered (non static) target with known device id and the address,
+// the healthiest target is used if multiple devices were seen with the address, nil if not found
+func (d *serviceDiscovery) findTargetByAddress(address string) *DiscoveryTarget {
+	var ret *DiscoveryTarget
+	for _, target := range d.targetList {
+		if target.Address != address || target.Static || target.DeviceId == "" {
+			continue
+		}
+		if ret == nil || target.Health > ret.Health || (target.Health == ret.Health && target.DeviceId < ret.DeviceId) {
+			ret = target
+		}
+	}
+	return ret
+}
+
 func (d *serviceDiscovery) cleanup() {
 	for address, target := range d.targetList {
 		if target.Health <= TargetHealthDead {
@@ -315,6 +497,21 @@ func (d *serviceDiscovery) cleanup() {
 	}
 }
 
+// GetTarget returns the known target for address (and optional port), nil if the target is not known
+func (d *serviceDiscovery) GetTarget(address string, port int) *DiscoveryTarget {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	for _, row := range d.targetList {
+		if (row.Address == address || row.Hostname == address) && (port == 0 || row.Port == port) {
+			target := *row
+			return &target
+		}
+	}
+
+	return nil
+}
+
 func (d *serviceDiscovery) GetTargetList() []DiscoveryTarget {
 	d.lock.RLock()
 	defer d.lock.RUnlock()
@@ -330,6 +527,22 @@ func (d *serviceDiscovery) GetTargetList() []DiscoveryTarget {
 }
 
 func discoveryTargetFromStatic(entry string, deviceType string) DiscoveryTarget {
+	target, err := ParseTarget(entry, deviceType)
+	if err != nil {
+		panic(err)
+	}
+
+	return target
+}
+
+// ParseTarget builds a static DiscoveryTarget from an host[:port] entry
+func ParseTarget(entry string, deviceType string) (DiscoveryTarget, error) {
+	switch deviceType {
+	case TargetTypeShellyPlug, TargetTypeShellyPlus, TargetTypeShellyPro:
+	default:
+		return DiscoveryTarget{}, fmt.Errorf(`invalid target type "%v"`, deviceType)
+	}
+
 	parts := strings.Split(entry, ":")
 	var name string
 	var port int
@@ -338,13 +551,17 @@ func discoveryTargetFromStatic(entry string, deviceType string) DiscoveryTarget
 		var err error
 		port, err = strconv.Atoi(parts[1])
 		if err != nil {
-			panic(err)
+			return DiscoveryTarget{}, fmt.Errorf(`invalid port in target "%v": %w`, entry, err)
 		}
 	} else {
 		name = parts[0]
 		port = 80
 	}
 
+	if name == "" {
+		return DiscoveryTarget{}, fmt.Errorf(`invalid target "%v"`, entry)
+	}
+
 	return DiscoveryTarget{
 		Hostname: name,
 		Port:     port,
@@ -352,5 +569,5 @@ func discoveryTargetFromStatic(entry string, deviceType string) DiscoveryTarget
 		Type:     deviceType,
 		Static:   true,
 		Health:   TargetHealthGood,
-	}
+	}, nil
 }
@@ -0,0 +1,120 @@
+package discovery
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/webdevops/go-common/log/slogger"
+)
+
+func newTestDiscovery() *serviceDiscovery {
+	return &serviceDiscovery{
+		logger:     slogger.NewDiscardLogger(),
+		targetList: map[string]*DiscoveryTarget{},
+	}
+}
+
+func targetKeys(d *serviceDiscovery) []string {
+	ret := []string{}
+	for key := range d.targetList {
+		ret = append(ret, key)
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+func TestUpdateTargetListDeviceIdTransition(t *testing.T) {
+	addressTarget := DiscoveryTarget{Hostname: "shellyplus1pm", Address: "192.168.1.20", Port: 80, Type: TargetTypeShellyPlus}
+	deviceTarget := DiscoveryTarget{Hostname: "shellyplus1pm-a8032ab1b1b0", Address: "192.168.1.20", Port: 80, Type: TargetTypeShellyPlus, DeviceId: "A8032AB1B1B0"}
+
+	tests := []struct {
+		name    string
+		updates [][]DiscoveryTarget
+	}{
+		{
+			name:    "device id known in next update",
+			updates: [][]DiscoveryTarget{{addressTarget}, {deviceTarget}},
+		},
+		{
+			name:    "address target before device target in same update",
+			updates: [][]DiscoveryTarget{{addressTarget}, {addressTarget, deviceTarget}},
+		},
+		{
+			name:    "device target before address target in same update",
+			updates: [][]DiscoveryTarget{{addressTarget}, {deviceTarget, addressTarget}},
+		},
+		{
+			name:    "address target after device id is known",
+			updates: [][]DiscoveryTarget{{deviceTarget}, {addressTarget}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newTestDiscovery()
+			for _, update := range test.updates {
+				d.updateTargetList(update)
+			}
+
+			if keys := targetKeys(d); !slices.Equal(keys, []string{"A8032AB1B1B0"}) {
+				t.Fatalf("expected only device id keyed target, got %v", keys)
+			}
+			if target := d.targetList["A8032AB1B1B0"]; target.Address != "192.168.1.20" || target.Health != TargetHealthGood {
+				t.Errorf("unexpected target %+v", target)
+			}
+		})
+	}
+}
+
+func TestSetTargetDeviceId(t *testing.T) {
+	d := newTestDiscovery()
+	d.updateTargetList([]DiscoveryTarget{{Hostname: "shellyplus1pm", Address: "192.168.1.20", Port: 80, Type: TargetTypeShellyPlus}})
+
+	// device id from /shelly
+	d.SetTargetDeviceId("192.168.1.20", "a8:03:2a:b1:b1:b0")
+	if keys := targetKeys(d); !slices.Equal(keys, []string{"A8032AB1B1B0"}) {
+		t.Fatalf("expected target to be keyed by device id, got %v", keys)
+	}
+
+	// next discovery without device id keeps the device id
+	d.updateTargetList([]DiscoveryTarget{{Hostname: "shellyplus1pm", Address: "192.168.1.20", Port: 80, Type: TargetTypeShellyPlus}})
+	if keys := targetKeys(d); !slices.Equal(keys, []string{"A8032AB1B1B0"}) {
+		t.Fatalf("expected target to stay keyed by device id, got %v", keys)
+	}
+}
+
+func TestUpdateTargetListKeepsOtherDevices(t *testing.T) {
+	d := newTestDiscovery()
+
+	// another device got the address of the previous device (eg. DHCP), both are known by device id
+	d.updateTargetList([]DiscoveryTarget{
+		{Hostname: "shellyplus1pm-a8032ab1b1b0", Address: "192.168.1.20", Port: 80, Type: TargetTypeShellyPlus, DeviceId: "A8032AB1B1B0"},
+	})
+	d.updateTargetList([]DiscoveryTarget{
+		{Hostname: "shellyplus1pm-a8032ab1b1b1", Address: "192.168.1.20", Port: 80, Type: TargetTypeShellyPlus, DeviceId: "A8032AB1B1B1"},
+	})
+
+	if keys := targetKeys(d); !slices.Equal(keys, []string{"A8032AB1B1B0", "A8032AB1B1B1"}) {
+		t.Fatalf("expected both device targets, got %v", keys)
+	}
+	if d.targetList["A8032AB1B1B0"].Health != TargetHealthLow {
+		t.Errorf("expected previous device target to be unhealthy, got %+v", d.targetList["A8032AB1B1B0"])
+	}
+
+	// discovery without device id is assigned to the current device (map iteration order must not matter)
+	for range 20 {
+		// previous device target is kept until it's dead
+		d.targetList["A8032AB1B1B0"].Health = TargetHealthLow
+
+		d.updateTargetList([]DiscoveryTarget{
+			{Hostname: "shellyplus1pm", Address: "192.168.1.20", Port: 80, Type: TargetTypeShellyPlus},
+		})
+
+		if keys := targetKeys(d); !slices.Equal(keys, []string{"A8032AB1B1B0", "A8032AB1B1B1"}) {
+			t.Fatalf("expected both device targets, got %v", keys)
+		}
+		if d.targetList["A8032AB1B1B1"].Health != TargetHealthGood {
+			t.Fatalf("expected current device target to be healthy, got %+v", d.targetList["A8032AB1B1B1"])
+		}
+	}
+}
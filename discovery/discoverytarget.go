@@ -2,18 +2,48 @@ package discovery
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webdevops/shelly-plug-exporter/credentials"
+)
+
+var (
+	deviceIdRegexp = regexp.MustCompile(`^[0-9A-F]{12}$`)
 )
 
 type (
 	DiscoveryTarget struct {
 		DeviceName *string `json:"deviceName"`
-		Hostname   string  `json:"hostname"`
-		Address    string  `json:"address"`
-		Port       int     `json:"port"`
-		Health     int     `json:"health"`
-		Type       string  `json:"type"`
-		Static     bool    `json:"isStatic"`
-		Generation string  `json:"generation"`
+		// DeviceId is the stable identity of the device (mac address), from the mDNS hostname or /shelly
+		DeviceId   string `json:"deviceId,omitempty"`
+		Hostname   string `json:"hostname"`
+		Address    string `json:"address"`
+		Port       int    `json:"port"`
+		Health     int    `json:"health"`
+		Type       string `json:"type"`
+		Static     bool   `json:"isStatic"`
+		Generation string `json:"generation"`
+
+		// AdHoc is set for targets passed via /probe?target= which are neither discovered nor configured
+		AdHoc bool `json:"-"`
+
+		// CircuitBreaker is the circuit breaker state of the target (only set for /targets)
+		CircuitBreaker string `json:"circuitBreaker,omitempty"`
+
+		// per device settings (config file)
+		Timeout      time.Duration           `json:"timeout,omitempty"`
+		PollInterval time.Duration           `json:"pollInterval,omitempty"`
+		Auth         *credentials.Credential `json:"-"`
+		Labels       map[string]string       `json:"labels,omitempty"`
+	}
+
+	// HttpSdTargetGroup is a target group in the Prometheus HTTP service discovery format
+	HttpSdTargetGroup struct {
+		Targets []string          `json:"targets"`
+		Labels  map[string]string `json:"labels"`
 	}
 )
 
@@ -21,6 +51,15 @@ func (t *DiscoveryTarget) Name() string {
 	return fmt.Sprintf(`%v [%v]`, t.Hostname, t.Address)
 }
 
+// Key returns the key of the target: discovered targets are identified by the device id (if known),
+// static targets are configured by address so they are also keyed by address
+func (t *DiscoveryTarget) Key() string {
+	if t.Static || t.DeviceId == "" {
+		return t.Address
+	}
+	return t.DeviceId
+}
+
 func (t *DiscoveryTarget) BaseUrl() string {
 	if t.Port == 80 {
 		return fmt.Sprintf("http://%v", t.Address)
@@ -28,3 +67,56 @@ func (t *DiscoveryTarget) BaseUrl() string {
 		return fmt.Sprintf("http://%v:%v", t.Address, t.Port)
 	}
 }
+
+func (t *DiscoveryTarget) HostPort() string {
+	if t.Port == 80 {
+		return t.Address
+	} else {
+		return fmt.Sprintf("%v:%v", t.Address, t.Port)
+	}
+}
+
+func (t *DiscoveryTarget) HttpSdTargetGroup() HttpSdTargetGroup {
+	deviceName := ""
+	if t.DeviceName != nil {
+		deviceName = *t.DeviceName
+	}
+
+	group := HttpSdTargetGroup{
+		Targets: []string{t.HostPort()},
+		Labels: map[string]string{
+			"__meta_shelly_type":        t.Type,
+			"__meta_shelly_generation":  t.Generation,
+			"__meta_shelly_hostname":    t.Hostname,
+			"__meta_shelly_device_name": deviceName,
+			"__meta_shelly_device_id":   t.DeviceId,
+			"__meta_shelly_health":      strconv.Itoa(t.Health),
+			"__meta_shelly_static":      strconv.FormatBool(t.Static),
+		},
+	}
+
+	// custom labels are prefixed so they cannot override reserved or __meta_shelly_* labels
+	for name, value := range t.Labels {
+		group.Labels["__meta_shelly_label_"+name] = value
+	}
+
+	return group
+}
+
+// NormalizeDeviceId returns the device id (mac address) in the format used by /shelly (eg. A8032AB1B1B0)
+func NormalizeDeviceId(mac string) string {
+	mac = strings.ToUpper(mac)
+	mac = strings.ReplaceAll(mac, ":", "")
+	mac = strings.ReplaceAll(mac, "-", "")
+	return mac
+}
+
+// deviceIdFromHostname returns the device id from the mDNS hostname (eg. shellyplus1pm-a8032ab1b1b0)
+func deviceIdFromHostname(hostname string) string {
+	if pos := strings.LastIndex(hostname, "-"); pos >= 0 {
+		if deviceId := NormalizeDeviceId(hostname[pos+1:]); deviceIdRegexp.MatchString(deviceId) {
+			return deviceId
+		}
+	}
+	return ""
+}
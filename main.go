@@ -9,6 +9,7 @@ import (
 	"runtime"
 
 	"github.com/jessevdk/go-flags"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/webdevops/shelly-plug-exporter/config"
@@ -33,6 +34,9 @@ var (
 func main() {
 	initArgparser()
 	initLogger()
+	initConfigFile()
+	initCredentials()
+	initShellyProber()
 
 	logger.Info(fmt.Sprintf("starting shellyplug-plug-exporter v%s (%s; %s; by %v at %v)", gitTag, gitCommit, runtime.Version(), Author, buildDate))
 	logger.Info(string(Opts.GetJson()))
@@ -85,9 +89,39 @@ func startHttpServer() {
 		Opts.Shelly.Host.ShellyPlug,
 		Opts.Shelly.Host.ShellyPlus,
 		Opts.Shelly.Host.ShellyPro,
+		buildDiscoverySettings(),
 	)
 
-	mux.Handle("/metrics", promhttp.Handler())
+	if Opts.Shelly.Push.Enabled {
+		initPush()
+		mux.HandleFunc("/push", shellyPushHandler)
+	}
+
+	if Opts.Shelly.CoIoT.Enabled {
+		initCoIoT()
+	}
+
+	if Opts.Shelly.MQTT.Enabled {
+		initMQTT()
+	}
+
+	if Opts.Shelly.Websocket.Enabled {
+		initWebsocket()
+	}
+
+	if Opts.Shelly.Websocket.Server.Enabled {
+		mux.Handle("/websocket", initWebsocketServer())
+	}
+
+	if Opts.Shelly.Polling.Enabled {
+		initPolling()
+		mux.Handle("/metrics", promhttp.InstrumentMetricHandler(
+			prometheus.DefaultRegisterer,
+			promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, poller}, promhttp.HandlerOpts{}),
+		))
+	} else {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
 	mux.HandleFunc("/probe", shellyProbeDiscovery)
 	mux.HandleFunc("/targets", shellyProbeDiscoveryTargets)
 
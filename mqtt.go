@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log/slog"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/webdevops/shelly-plug-exporter/shellymqtt"
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+var (
+	mqttStore *shellystate.Store
+)
+
+func initMQTT() {
+	mqttStore = shellystate.NewStore(Opts.Shelly.MQTT.Retention)
+
+	mqttLogger := logger.With(slog.String("module", "mqtt"))
+	subscriber := shellymqtt.NewSubscriber(mqttLogger, mqttStore, Opts.Shelly.MQTT.Topics)
+
+	options := mqtt.NewClientOptions()
+	options.AddBroker(Opts.Shelly.MQTT.Broker)
+	options.SetClientID(Opts.Shelly.MQTT.ClientId)
+	if Opts.Shelly.MQTT.Username != "" {
+		options.SetUsername(Opts.Shelly.MQTT.Username)
+		options.SetPassword(Opts.Shelly.MQTT.Password)
+	}
+
+	mqttLogger.Info("starting MQTT subscriber", slog.String("broker", Opts.Shelly.MQTT.Broker), slog.Any("topics", Opts.Shelly.MQTT.Topics))
+	subscriber.Connect(options)
+}
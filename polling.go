@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/webdevops/shelly-plug-exporter/shellypoller"
+)
+
+var (
+	poller *shellypoller.Poller
+)
+
+func initPolling() {
+	pollingLogger := logger.With(slog.String("module", "polling"))
+	poller = shellypoller.NewPoller(pollingLogger, newShellyProber, Opts.Shelly.Polling.Interval, Opts.Shelly.Polling.MaxAge)
+
+	pollingLogger.Info("starting background polling", slog.Duration("interval", Opts.Shelly.Polling.Interval))
+	go poller.Run(context.Background())
+}
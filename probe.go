@@ -13,6 +13,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/webdevops/go-common/log/slogger"
 
+	"github.com/webdevops/shelly-plug-exporter/discovery"
 	"github.com/webdevops/shelly-plug-exporter/shellyplug"
 )
 
@@ -20,13 +21,22 @@ const (
 	DefaultTimeout = 30
 )
 
+// initShellyProber sets the global prober settings (shared by all probes)
+func initShellyProber() {
+	shellyplug.SetConcurrencyLimits(Opts.Shelly.Request.Concurrency.Max, Opts.Shelly.Request.Concurrency.PerDevice)
+	shellyplug.SetCircuitBreaker(Opts.Shelly.CircuitBreaker.Threshold, Opts.Shelly.CircuitBreaker.Cooldown, Opts.Shelly.CircuitBreaker.CooldownMax)
+	shellyplug.SetTargetLabel(Opts.Shelly.TargetLabel)
+}
+
 func newShellyProber(ctx context.Context, registry *prometheus.Registry, logger *slogger.Logger) *shellyplug.ShellyPlug {
 	sp := shellyplug.New(ctx, registry, logger)
 	sp.SetUserAgent(UserAgent + gitTag)
 	sp.SetTimeout(Opts.Shelly.Request.Timeout)
 	sp.EnableRetry(Opts.Shelly.Request.RetryCount, Opts.Shelly.Request.RetryWaitTime, Opts.Shelly.Request.RetryWaitTimeMax)
-	if len(Opts.Shelly.Auth.Username) >= 1 {
-		sp.SetHttpAuth(Opts.Shelly.Auth.Username, Opts.Shelly.Auth.Password)
+	sp.SetCredentials(credentialStore, Opts.Shelly.Auth.AdHoc)
+
+	if websocketManager != nil {
+		sp.UseStateMirror(websocketManager)
 	}
 
 	return sp
@@ -45,7 +55,25 @@ func shellyProbeDiscoveryTargets(w http.ResponseWriter, r *http.Request) {
 
 	targets := sp.GetTargets()
 
-	body, err := json.Marshal(targets)
+	var body []byte
+	var err error
+	switch r.URL.Query().Get("format") {
+	case "http_sd":
+		// prometheus http service discovery format
+		targetGroups := []discovery.HttpSdTargetGroup{}
+		for _, target := range targets {
+			targetGroups = append(targetGroups, target.HttpSdTargetGroup())
+		}
+		body, err = json.Marshal(targetGroups)
+	case "", "json":
+		for num := range targets {
+			targets[num].CircuitBreaker = shellyplug.GetCircuitBreakerState(targets[num].Key())
+		}
+		body, err = json.Marshal(targets)
+	default:
+		http.Error(w, fmt.Sprintf("unsupported format: %s", r.URL.Query().Get("format")), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		contextLogger.Error("failed to marshal object", slog.Any("error", err))
 		http.Error(w, fmt.Sprintf("unable to marshal targets to json: %s", err), http.StatusBadRequest)
@@ -77,18 +105,89 @@ func shellyProbeDiscovery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutSeconds*float64(time.Second)))
+	// requests are cancelled before Prometheus gives up so partial results can still be sent
+	timeout := time.Duration(timeoutSeconds * float64(time.Second))
+	if timeout > Opts.Shelly.Request.TimeoutOffset {
+		timeout -= Opts.Shelly.Request.TimeoutOffset
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	r = r.WithContext(ctx)
+
+	gatherers := prometheus.Gatherers{registry}
 
 	sp := newShellyProber(ctx, registry, contextLogger)
-	sp.UseDiscovery()
+	if targetParam := r.URL.Query().Get("target"); targetParam != "" {
+		// single target mode (blackbox style)
+		target, err := buildProbeTarget(targetParam, r.URL.Query().Get("type"))
+		if err != nil {
+			contextLogger.Error("invalid target", slog.Any("error", err))
+			http.Error(w, fmt.Sprintf("invalid target: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		if snapshot, ok := pollerSnapshot(target.Key()); ok {
+			// target is polled in background
+			gatherers = prometheus.Gatherers{snapshot}
+		} else {
+			sp.SetTargets([]discovery.DiscoveryTarget{target})
+		}
+	} else {
+		if poller != nil {
+			// targets are polled in background, only the state stores are collected per request
+			gatherers = append(gatherers, poller)
+		} else {
+			sp.UseDiscovery()
+		}
+
+		if pushStore != nil {
+			sp.UseStateStore(pushStore)
+		}
+		if coiotStore != nil {
+			sp.UseStateStore(coiotStore)
+		}
+		if websocketServerStore != nil {
+			sp.UseStateStore(websocketServerStore)
+		}
+		if mqttStore != nil {
+			sp.UseStateStore(mqttStore)
+		}
+	}
 	sp.Run()
 
-	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	h := promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})
 	h.ServeHTTP(w, r)
 }
 
+// pollerSnapshot returns the snapshot of the target if background polling is enabled
+func pollerSnapshot(key string) (prometheus.Gatherer, bool) {
+	if poller == nil {
+		return nil, false
+	}
+	return poller.GathererFor(key)
+}
+
+// buildProbeTarget returns the target from servicediscovery (if known) or builds a new static target
+func buildProbeTarget(entry, targetType string) (discovery.DiscoveryTarget, error) {
+	if targetType == "" {
+		targetType = discovery.TargetTypeShellyPlug
+	}
+
+	target, err := discovery.ParseTarget(entry, targetType)
+	if err != nil {
+		return target, err
+	}
+
+	if discovery.ServiceDiscovery != nil {
+		if knownTarget := discovery.ServiceDiscovery.GetTarget(target.Address, target.Port); knownTarget != nil {
+			return *knownTarget, nil
+		}
+	}
+
+	target.AdHoc = true
+	return target, nil
+}
+
 func buildContextLoggerFromRequest(r *http.Request) *slogger.Logger {
 	return logger.With(slog.Group("request", slog.String("path", r.URL.Path)))
 }
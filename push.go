@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevops/shelly-plug-exporter/coiot"
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+const (
+	PushMaxBodySize = 1 * 1024 * 1024
+)
+
+var (
+	pushStore  *shellystate.Store
+	coiotStore *shellystate.Store
+)
+
+func initPush() {
+	pushStore = shellystate.NewStore(Opts.Shelly.Push.Retention)
+	pushStore.SetMaxDevices(Opts.Shelly.Push.MaxDevices)
+}
+
+func initCoIoT() {
+	coiotStore = shellystate.NewStore(Opts.Shelly.CoIoT.Retention)
+
+	coiotLogger := logger.With(slog.String("module", "coiot"))
+	listener := coiot.NewListener(coiotLogger, coiotStore)
+	if err := listener.Listen(Opts.Shelly.CoIoT.Bind, Opts.Shelly.CoIoT.Interface); err != nil {
+		logger.Fatal(fmt.Sprintf("failed to start CoIoT listener: %v", err))
+	}
+
+	coiotLogger.Info("starting CoIoT listener", slog.String("bind", Opts.Shelly.CoIoT.Bind))
+	go listener.Run()
+}
+
+// shellyPushHandler receives device states from sleeping devices
+//
+//	gen1 action urls: GET /push?id=shellyht-XXXXXX&temp=21.5&hum=45
+//	gen2 webhooks:    GET /push?id=shellyhtg3-XXXXXXXXXXXX&gen=2&tC=21.5
+//	gen2 status:      POST /push with NotifyStatus/NotifyFullStatus frame as body
+func shellyPushHandler(w http.ResponseWriter, r *http.Request) {
+	contextLogger := buildContextLoggerFromRequest(r)
+
+	if Opts.Shelly.Push.Token != "" && !checkPushToken(r, Opts.Shelly.Push.Token) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	remoteAddress, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteAddress = r.RemoteAddr
+	}
+
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		body, err := io.ReadAll(io.LimitReader(r.Body, PushMaxBodySize))
+		if err != nil {
+			contextLogger.Error("failed to read body", slog.Any("error", err))
+			http.Error(w, fmt.Sprintf("failed to read body: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		frame, err := shellystate.ParseGen2Frame(body)
+		if err != nil {
+			// no frame, try plain status document with id from query
+			status := map[string]interface{}{}
+			if err := json.Unmarshal(body, &status); err != nil || r.URL.Query().Get("id") == "" {
+				contextLogger.Error("failed to parse push body", slog.Any("error", err))
+				http.Error(w, "invalid body, expected gen2 notification frame or status with id parameter", http.StatusBadRequest)
+				return
+			}
+			frame = &shellystate.Gen2Frame{
+				Src:    r.URL.Query().Get("id"),
+				Method: "NotifyStatus",
+				Params: status,
+			}
+		}
+
+		if frame.Src == "" {
+			http.Error(w, "device id (src) is missing", http.StatusBadRequest)
+			return
+		}
+
+		if !acceptPushDevice(w, frame.Src, contextLogger) {
+			return
+		}
+
+		contextLogger.Debug("received pushed status", slog.String("device", frame.Src), slog.String("method", frame.Method))
+		pushStore.ApplyGen2Frame(frame, shellystate.SourcePush, remoteAddress)
+	} else {
+		deviceId := r.URL.Query().Get("id")
+		if deviceId == "" {
+			http.Error(w, "id parameter is missing", http.StatusBadRequest)
+			return
+		}
+
+		if !acceptPushDevice(w, deviceId, contextLogger) {
+			return
+		}
+
+		values := shellystate.ParsePushValues(r.URL.Query())
+		contextLogger.Debug("received pushed values", slog.String("device", deviceId), slog.Int("values", len(values)))
+
+		generation := 1
+		if val := r.URL.Query().Get("gen"); val != "" {
+			if generation, err = strconv.Atoi(val); err != nil {
+				http.Error(w, fmt.Sprintf("invalid gen parameter: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		pushStore.ApplyValues(deviceId, generation, shellystate.SourcePush, remoteAddress, values)
+	}
+
+	if _, err := fmt.Fprint(w, "Ok"); err != nil {
+		contextLogger.Error(err.Error())
+	}
+}
+
+// acceptPushDevice rejects pushes of new devices if the push store is full
+func acceptPushDevice(w http.ResponseWriter, deviceId string, logger *slogger.Logger) bool {
+	if pushStore.Accepts(deviceId) {
+		return true
+	}
+
+	logger.Warn("rejected pushed device, maximum number of devices reached", slog.String("device", deviceId))
+	http.Error(w, "maximum number of devices reached", http.StatusTooManyRequests)
+	return false
+}
+
+// checkPushToken checks the token passed as ?token=xxx or bearer token (also used by the websocket endpoint)
+func checkPushToken(r *http.Request, expectedToken string) bool {
+	token := r.URL.Query().Get("token")
+	if val := r.Header.Get("Authorization"); strings.HasPrefix(val, "Bearer ") {
+		token = strings.TrimPrefix(val, "Bearer ")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expectedToken)) == 1
+}
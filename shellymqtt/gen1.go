@@ -0,0 +1,194 @@
+package shellymqtt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+type (
+	gen1Announce struct {
+		ID    string `json:"id"`
+		Model string `json:"model"`
+		Mac   string `json:"mac"`
+		IP    string `json:"ip"`
+	}
+
+	gen1LightStatus struct {
+		Ison       bool `json:"ison"`
+		Brightness *int `json:"brightness"`
+		Gain       *int `json:"gain"`
+	}
+
+	gen1InputEvent struct {
+		Event    string `json:"event"`
+		EventCnt int    `json:"event_cnt"`
+	}
+)
+
+var (
+	// gen1SensorMapping maps the gen1 topics shellies/<id>/<topic> to sensor values
+	gen1SensorMapping = map[string]shellystate.Value{
+		"sensor/temperature": {Block: "sensor_0", Type: shellystate.SensorTypeTemperature, Name: "temperature", Unit: "C"},
+		"sensor/humidity":    {Block: "sensor_0", Type: shellystate.SensorTypeHumidity, Name: "humidity", Unit: "%"},
+		"sensor/lux":         {Block: "sensor_0", Type: shellystate.SensorTypeLuminosity, Name: "luminosity", Unit: "lux"},
+		"sensor/battery":     {Block: "device", Type: shellystate.SensorTypeBattery, Name: "battery", Unit: "%"},
+		"sensor/flood":       {Block: "sensor_0", Type: shellystate.SensorTypeAlarm, Name: "flood"},
+		"sensor/state":       {Block: "sensor_0", Type: shellystate.SensorTypeStatus, Name: "dwIsOpened"},
+		"temperature":        {Block: "device", Type: shellystate.SensorTypeTemperature, Name: "deviceTemp", Unit: "C"},
+		"overtemperature":    {Block: "device", Type: shellystate.SensorTypeAlarm, Name: "overtemp"},
+	}
+
+	// gen1ChannelMapping maps the gen1 channel topics shellies/<id>/<channel>/<N>/<topic> to sensor values
+	gen1ChannelMapping = map[string]shellystate.Value{
+		"power":          {Type: shellystate.SensorTypePower, Name: "power", Unit: "W"},
+		"energy":         {Type: shellystate.SensorTypeEnergy, Name: "energy", Unit: "Wmin"},
+		"voltage":        {Type: shellystate.SensorTypeVoltage, Name: "voltage", Unit: "V"},
+		"current":        {Type: shellystate.SensorTypeCurrent, Name: "current", Unit: "A"},
+		"total":          {Type: shellystate.SensorTypeEnergy, Name: "energy", Unit: "Wh"},
+		"total_returned": {Type: shellystate.SensorTypeEnergy, Name: "returnedEnergy", Unit: "Wh"},
+		"pos":            {Type: shellystate.SensorTypeStatus, Name: "rollerPos", Unit: "%"},
+	}
+)
+
+// handleGen1Message applies the gen1 topics (without shellies/ prefix)
+func (s *Subscriber) handleGen1Message(topic string, payload []byte) error {
+	id, subTopic, found := strings.Cut(topic, "/")
+	if !found || id == "announce" {
+		// shellies/announce
+		return s.handleGen1Announce(payload)
+	}
+
+	switch subTopic {
+	case "announce":
+		return s.handleGen1Announce(payload)
+	case "online":
+		// gen2 devices might also use shellies/<id> as prefix
+		s.handleOnline(id, payload)
+		s.handleOnline(gen1TopicPrefix+id, payload)
+		return nil
+	}
+
+	values, err := parseGen1Topic(subTopic, string(payload))
+	if err != nil {
+		return err
+	}
+
+	if len(values) > 0 {
+		s.store.ApplyValues(id, 1, shellystate.SourceMqtt, "", values)
+	}
+	return nil
+}
+
+func (s *Subscriber) handleGen1Announce(payload []byte) error {
+	announce := gen1Announce{}
+	if err := json.Unmarshal(payload, &announce); err != nil {
+		return fmt.Errorf("failed to decode announce: %w", err)
+	}
+
+	if announce.ID == "" {
+		return errors.New("announce without device id")
+	}
+
+	s.store.UpdateDevice(announce.ID, func(device *shellystate.Device) {
+		device.Generation = 1
+		device.Source = shellystate.SourceMqtt
+		device.Model = announce.Model
+		device.MacAddress = strings.ToUpper(announce.Mac)
+		if announce.IP != "" {
+			device.Address = announce.IP
+		}
+	})
+	return nil
+}
+
+// parseGen1Topic parses the gen1 topic (eg. relay/0/power) into sensor values (CoIoT naming)
+func parseGen1Topic(topic, payload string) ([]shellystate.Value, error) {
+	if mapping, ok := gen1SensorMapping[topic]; ok {
+		value, err := shellystate.ParseValue(payload)
+		if err != nil {
+			return nil, err
+		}
+		mapping.Value = value
+		return []shellystate.Value{mapping}, nil
+	}
+
+	parts := strings.Split(topic, "/")
+	if len(parts) < 2 {
+		return nil, nil
+	}
+	block := fmt.Sprintf("%s_%s", parts[0], parts[1])
+
+	switch {
+	case len(parts) == 2 && (parts[0] == "relay" || parts[0] == "light"):
+		// state of relay: on, off, overpower
+		ret := []shellystate.Value{
+			{Block: block, Type: shellystate.SensorTypeStatus, Name: "output", Value: boolToFloat64(payload == "on")},
+		}
+		if parts[0] == "relay" {
+			ret = append(ret, shellystate.Value{Block: block, Type: shellystate.SensorTypeAlarm, Name: "overpower", Value: boolToFloat64(payload == "overpower")})
+		}
+		return ret, nil
+	case len(parts) == 2 && parts[0] == "input":
+		value, err := shellystate.ParseValue(payload)
+		if err != nil {
+			return nil, err
+		}
+		return []shellystate.Value{{Block: block, Type: shellystate.SensorTypeStatus, Name: "input", Value: value}}, nil
+	case len(parts) == 2 && parts[0] == "input_event":
+		event := gen1InputEvent{}
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			return nil, err
+		}
+		return []shellystate.Value{{Block: fmt.Sprintf("input_%s", parts[1]), Type: shellystate.SensorTypeEventCount, Name: "inputEventCnt", Value: float64(event.EventCnt)}}, nil
+	case len(parts) == 3 && parts[0] == "light" && parts[2] == "status":
+		status := gen1LightStatus{}
+		if err := json.Unmarshal([]byte(payload), &status); err != nil {
+			return nil, err
+		}
+
+		ret := []shellystate.Value{
+			{Block: block, Type: shellystate.SensorTypeStatus, Name: "output", Value: boolToFloat64(status.Ison)},
+		}
+		switch {
+		case status.Brightness != nil:
+			ret = append(ret, shellystate.Value{Block: block, Type: shellystate.SensorTypeStatus, Name: "brightness", Value: float64(*status.Brightness)})
+		case status.Gain != nil:
+			// rgbw devices in color mode are using gain as brightness
+			ret = append(ret, shellystate.Value{Block: block, Type: shellystate.SensorTypeStatus, Name: "brightness", Value: float64(*status.Gain)})
+		}
+		return ret, nil
+	case len(parts) == 3:
+		// channel values: relay/0/power, emeter/0/total, roller/0/pos, ...
+		mapping, ok := gen1ChannelMapping[parts[2]]
+		if !ok {
+			return nil, nil
+		}
+
+		value, err := shellystate.ParseValue(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		// position is -1 if roller is not calibrated
+		if mapping.Name == "rollerPos" && value < 0 {
+			return nil, nil
+		}
+
+		mapping.Block = block
+		mapping.Value = value
+		return []shellystate.Value{mapping}, nil
+	}
+
+	return nil, nil
+}
+
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
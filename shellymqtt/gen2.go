@@ -0,0 +1,37 @@
+package shellymqtt
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+// handleGen2Status applies the component status published to <prefix>/status/<component>
+func (s *Subscriber) handleGen2Status(prefix, component string, payload []byte) error {
+	status := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &status); err != nil {
+		return fmt.Errorf("failed to decode component status: %w", err)
+	}
+
+	s.store.ApplyGen2Status(prefix, shellystate.SourceMqtt, "", map[string]interface{}{component: status}, false)
+	return nil
+}
+
+// handleGen2Frame applies NotifyStatus and NotifyFullStatus frames published to <prefix>/events/rpc,
+// the prefix is used as device id (same as for status topics)
+func (s *Subscriber) handleGen2Frame(prefix string, payload []byte) error {
+	frame, err := shellystate.ParseGen2Frame(payload)
+	if err != nil {
+		return err
+	}
+
+	switch frame.Method {
+	case "NotifyFullStatus":
+		s.store.ApplyGen2Status(prefix, shellystate.SourceMqtt, "", frame.Params, true)
+	case "NotifyStatus":
+		s.store.ApplyGen2Status(prefix, shellystate.SourceMqtt, "", frame.Params, false)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package shellymqtt
+
+import (
+	"log/slog"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+const (
+	// gen1 devices are publishing to shellies/<id>/...
+	gen1TopicPrefix = "shellies/"
+)
+
+type (
+	// Subscriber receives device states from a MQTT broker
+	//
+	//	gen1: shellies/<id>/relay/0, shellies/<id>/sensor/temperature, shellies/announce, ...
+	//	gen2: <prefix>/status/<component>, <prefix>/events/rpc, <prefix>/online
+	Subscriber struct {
+		logger *slogger.Logger
+		store  *shellystate.Store
+		topics []string
+		client mqtt.Client
+	}
+)
+
+func NewSubscriber(logger *slogger.Logger, store *shellystate.Store, topics []string) *Subscriber {
+	return &Subscriber{
+		logger: logger,
+		store:  store,
+		topics: topics,
+	}
+}
+
+// Connect connects to the broker, topics are (re)subscribed on every connect
+func (s *Subscriber) Connect(options *mqtt.ClientOptions) {
+	options.SetAutoReconnect(true)
+	options.SetConnectRetry(true)
+	options.SetOnConnectHandler(func(client mqtt.Client) {
+		s.logger.Info("connected to MQTT broker")
+		for _, topic := range s.topics {
+			token := client.Subscribe(topic, 0, func(client mqtt.Client, msg mqtt.Message) {
+				s.HandleMessage(msg.Topic(), msg.Payload())
+			})
+			if token.Wait() && token.Error() != nil {
+				s.logger.Error("failed to subscribe MQTT topic", slog.String("topic", topic), slog.Any("error", token.Error()))
+			}
+		}
+	})
+	options.SetConnectionLostHandler(func(client mqtt.Client, err error) {
+		s.logger.Warn("lost connection to MQTT broker", slog.Any("error", err))
+	})
+
+	// connection is retried in background if the broker is not available
+	s.client = mqtt.NewClient(options)
+	s.client.Connect()
+}
+
+func (s *Subscriber) Close() {
+	if s.client != nil {
+		s.client.Disconnect(250)
+	}
+}
+
+// HandleMessage applies the message to the device state, unknown topics are ignored
+func (s *Subscriber) HandleMessage(topic string, payload []byte) {
+	var err error
+	switch {
+	case strings.Contains(topic, "/status/"):
+		prefix, component, _ := strings.Cut(topic, "/status/")
+		err = s.handleGen2Status(prefix, component, payload)
+	case strings.HasSuffix(topic, "/events/rpc"):
+		err = s.handleGen2Frame(strings.TrimSuffix(topic, "/events/rpc"), payload)
+	case strings.HasPrefix(topic, gen1TopicPrefix):
+		err = s.handleGen1Message(strings.TrimPrefix(topic, gen1TopicPrefix), payload)
+	case strings.HasSuffix(topic, "/online"):
+		s.handleOnline(strings.TrimSuffix(topic, "/online"), payload)
+	}
+
+	if err != nil {
+		s.logger.Debug("ignoring MQTT message", slog.String("topic", topic), slog.Any("error", err))
+	}
+}
+
+// handleOnline removes the device if it's going offline (last will), otherwise the values would be reported until expiry
+func (s *Subscriber) handleOnline(id string, payload []byte) {
+	if online, err := shellystate.ParseValue(string(payload)); err == nil && online == 0 {
+		s.logger.Debug("device is offline", slog.String("device", id))
+		s.store.RemoveDevice(id)
+	}
+}
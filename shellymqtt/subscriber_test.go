@@ -0,0 +1,198 @@
+package shellymqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+type testMessage struct {
+	topic   string
+	payload string
+}
+
+func newTestSubscriber() *Subscriber {
+	return NewSubscriber(slogger.NewDiscardLogger(), shellystate.NewStore(time.Minute), nil)
+}
+
+func findValue(device shellystate.Device, block, name string) (shellystate.Value, bool) {
+	for _, value := range device.Values {
+		if value.Block == block && value.Name == name {
+			return value, true
+		}
+	}
+	return shellystate.Value{}, false
+}
+
+func TestParseGen1Topic(t *testing.T) {
+	tests := []struct {
+		topic   string
+		payload string
+		wantErr bool
+		values  []shellystate.Value
+	}{
+		{topic: "relay/0", payload: "on", values: []shellystate.Value{
+			{Block: "relay_0", Type: shellystate.SensorTypeStatus, Name: "output", Value: 1},
+			{Block: "relay_0", Type: shellystate.SensorTypeAlarm, Name: "overpower", Value: 0},
+		}},
+		{topic: "relay/1", payload: "overpower", values: []shellystate.Value{
+			{Block: "relay_1", Type: shellystate.SensorTypeStatus, Name: "output", Value: 0},
+			{Block: "relay_1", Type: shellystate.SensorTypeAlarm, Name: "overpower", Value: 1},
+		}},
+		{topic: "relay/0/power", payload: "12.5", values: []shellystate.Value{
+			{Block: "relay_0", Type: shellystate.SensorTypePower, Name: "power", Unit: "W", Value: 12.5},
+		}},
+		{topic: "emeter/2/total_returned", payload: "1000", values: []shellystate.Value{
+			{Block: "emeter_2", Type: shellystate.SensorTypeEnergy, Name: "returnedEnergy", Unit: "Wh", Value: 1000},
+		}},
+		{topic: "roller/0/pos", payload: "-1"},
+		{topic: "sensor/temperature", payload: "21.5", values: []shellystate.Value{
+			{Block: "sensor_0", Type: shellystate.SensorTypeTemperature, Name: "temperature", Unit: "C", Value: 21.5},
+		}},
+		{topic: "light/0/status", payload: `{"ison":true,"brightness":40}`, values: []shellystate.Value{
+			{Block: "light_0", Type: shellystate.SensorTypeStatus, Name: "output", Value: 1},
+			{Block: "light_0", Type: shellystate.SensorTypeStatus, Name: "brightness", Value: 40},
+		}},
+		{topic: "input_event/0", payload: `{"event":"S","event_cnt":3}`, values: []shellystate.Value{
+			{Block: "input_0", Type: shellystate.SensorTypeEventCount, Name: "inputEventCnt", Value: 3},
+		}},
+		{topic: "relay/0/unknown", payload: "1"},
+		{topic: "sensor/temperature", payload: "invalid", wantErr: true},
+		{topic: "light/0/status", payload: "invalid", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.topic+"="+test.payload, func(t *testing.T) {
+			values, err := parseGen1Topic(test.topic, test.payload)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got values %+v", values)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(values) != len(test.values) {
+				t.Fatalf("expected values %+v, got %+v", test.values, values)
+			}
+			for num := range values {
+				if values[num] != test.values[num] {
+					t.Errorf("expected value %+v, got %+v", test.values[num], values[num])
+				}
+			}
+		})
+	}
+}
+
+func TestHandleMessageGen1(t *testing.T) {
+	subscriber := newTestSubscriber()
+	for _, msg := range []testMessage{
+		{topic: "shellies/announce", payload: `{"id":"shellyplug-s-6a6250","model":"SHPLG-S","mac":"a8032a6a6250","ip":"192.168.1.20"}`},
+		{topic: "shellies/shellyplug-s-6a6250/relay/0", payload: "on"},
+		{topic: "shellies/shellyplug-s-6a6250/relay/0/power", payload: "42.1"},
+		{topic: "shellies/shellyplug-s-6a6250/temperature", payload: "35.2"},
+	} {
+		subscriber.HandleMessage(msg.topic, []byte(msg.payload))
+	}
+
+	device, ok := subscriber.store.GetDevice("shellyplug-s-6a6250")
+	if !ok {
+		t.Fatal("device not found")
+	}
+
+	if device.Generation != 1 || device.Model != "SHPLG-S" || device.Address != "192.168.1.20" || device.Mac() != "A8032A6A6250" {
+		t.Errorf("unexpected device: %+v", device)
+	}
+
+	for _, expected := range []shellystate.Value{
+		{Block: "relay_0", Name: "output", Value: 1},
+		{Block: "relay_0", Name: "power", Value: 42.1},
+		{Block: "device", Name: "deviceTemp", Value: 35.2},
+	} {
+		if value, ok := findValue(device, expected.Block, expected.Name); !ok || value.Value != expected.Value {
+			t.Errorf("expected %v/%v=%v, got %+v", expected.Block, expected.Name, expected.Value, value)
+		}
+	}
+
+	// last will removes the device
+	subscriber.HandleMessage("shellies/shellyplug-s-6a6250/online", []byte("false"))
+	if _, ok := subscriber.store.GetDevice("shellyplug-s-6a6250"); ok {
+		t.Error("expected device to be removed after offline message")
+	}
+}
+
+func TestHandleMessageGen2(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []testMessage
+		apower   float64
+		output   bool
+	}{
+		{
+			name: "full status",
+			messages: []testMessage{
+				{topic: "shellyplus1pm-a8032ab1b1b0/events/rpc", payload: `{"src":"shellyplus1pm-a8032ab1b1b0","method":"NotifyFullStatus","params":{"ts":1700000000,"sys":{"mac":"A8032AB1B1B0"},"switch:0":{"id":0,"output":true,"apower":12.5}}}`},
+			},
+			apower: 12.5,
+			output: true,
+		},
+		{
+			name: "partial status merged into full status",
+			messages: []testMessage{
+				{topic: "shellyplus1pm-a8032ab1b1b0/events/rpc", payload: `{"src":"shellyplus1pm-a8032ab1b1b0","method":"NotifyFullStatus","params":{"switch:0":{"id":0,"output":true,"apower":12.5}}}`},
+				{topic: "shellyplus1pm-a8032ab1b1b0/events/rpc", payload: `{"src":"shellyplus1pm-a8032ab1b1b0","method":"NotifyStatus","params":{"switch:0":{"apower":20}}}`},
+			},
+			apower: 20,
+			output: true,
+		},
+		{
+			name: "component status topic",
+			messages: []testMessage{
+				{topic: "shellyplus1pm-a8032ab1b1b0/status/switch:0", payload: `{"id":0,"output":false,"apower":0}`},
+			},
+			apower: 0,
+			output: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subscriber := newTestSubscriber()
+			for _, msg := range test.messages {
+				subscriber.HandleMessage(msg.topic, []byte(msg.payload))
+			}
+
+			device, ok := subscriber.store.GetDevice("shellyplus1pm-a8032ab1b1b0")
+			if !ok {
+				t.Fatal("device not found")
+			}
+
+			if device.Generation != 2 || device.Source != shellystate.SourceMqtt || device.Mac() != "A8032AB1B1B0" {
+				t.Errorf("unexpected device: %+v", device)
+			}
+
+			status, ok := device.Components["switch:0"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("switch:0 not found in components %+v", device.Components)
+			}
+			if status["apower"] != test.apower || status["output"] != test.output {
+				t.Errorf("unexpected switch:0 status %+v", status)
+			}
+		})
+	}
+}
+
+func TestHandleMessageIgnoresInvalidPayload(t *testing.T) {
+	subscriber := newTestSubscriber()
+	subscriber.HandleMessage("shellyplus1pm-a8032ab1b1b0/events/rpc", []byte(`invalid`))
+	subscriber.HandleMessage("shellyplus1pm-a8032ab1b1b0/status/switch:0", []byte(`invalid`))
+
+	if devices := subscriber.store.GetDevices(); len(devices) != 0 {
+		t.Errorf("expected no devices, got %+v", devices)
+	}
+}
@@ -0,0 +1,183 @@
+package shellyplug
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevops/shelly-plug-exporter/discovery"
+)
+
+const (
+	CircuitBreakerClosed   = "closed"
+	CircuitBreakerOpen     = "open"
+	CircuitBreakerHalfOpen = "halfOpen"
+)
+
+var (
+	circuitBreakers = circuitBreakerList{
+		targets: map[string]*circuitBreaker{},
+	}
+)
+
+type (
+	// circuitBreakerList contains the circuit breakers of all targets (shared by all probes)
+	circuitBreakerList struct {
+		threshold   int
+		cooldown    time.Duration
+		cooldownMax time.Duration
+
+		lock    sync.Mutex
+		targets map[string]*circuitBreaker
+	}
+
+	// circuitBreaker opens after consecutive failed probes, while open the target is only probed after the
+	// cooldown (half open, one probe at a time) which is doubled after every failed probe
+	circuitBreaker struct {
+		state     string
+		failures  int
+		cooldown  time.Duration
+		openUntil time.Time
+	}
+)
+
+// SetCircuitBreaker sets the consecutive failed probes until the circuit breaker opens (0 = disabled)
+// and the cooldown until the target is probed again
+func SetCircuitBreaker(threshold int, cooldown, cooldownMax time.Duration) {
+	circuitBreakers.lock.Lock()
+	defer circuitBreakers.lock.Unlock()
+
+	circuitBreakers.threshold = threshold
+	circuitBreakers.cooldown = cooldown
+	circuitBreakers.cooldownMax = max(cooldown, cooldownMax)
+}
+
+// GetCircuitBreakerState returns the circuit breaker state of the target (see DiscoveryTarget.Key)
+func GetCircuitBreakerState(key string) string {
+	circuitBreakers.lock.Lock()
+	defer circuitBreakers.lock.Unlock()
+
+	if breaker, exists := circuitBreakers.targets[key]; exists {
+		return breaker.state
+	}
+	return CircuitBreakerClosed
+}
+
+// allow returns true if the target should be probed and the circuit breaker state, open circuit breakers are
+// switched to half open after the cooldown. Refused probes in half open state are waiting for the running probe
+func (l *circuitBreakerList) allow(key string) (bool, string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	breaker, exists := l.targets[key]
+	if !exists {
+		return true, CircuitBreakerClosed
+	}
+
+	switch breaker.state {
+	case CircuitBreakerOpen:
+		if time.Now().Before(breaker.openUntil) {
+			return false, breaker.state
+		}
+		breaker.state = CircuitBreakerHalfOpen
+		return true, breaker.state
+	case CircuitBreakerHalfOpen:
+		// probe is already running
+		return false, breaker.state
+	default:
+		return true, breaker.state
+	}
+}
+
+// recordCircuitBreaker records the probe result in the circuit breaker of key, probes cancelled by the scrape deadline are not counted
+func (sp *ShellyPlug) recordCircuitBreaker(target discovery.DiscoveryTarget, key string, logger *slogger.Logger, success bool) {
+	var state string
+	if !success && sp.ctx.Err() != nil {
+		circuitBreakers.release(key)
+		state = GetCircuitBreakerState(key)
+	} else {
+		var cooldown time.Duration
+		state, cooldown = circuitBreakers.record(key, success)
+		if cooldown > 0 {
+			logger.Warn("circuit breaker is open, probing shelly device less often", slog.Duration("cooldown", cooldown))
+		}
+	}
+
+	sp.prometheus.circuitBreaker.With(prometheus.Labels{"target": TargetLabel(target)}).Set(circuitBreakerStateValue(state))
+}
+
+// record records the result of the probe and returns the new circuit breaker state
+// (and the cooldown if the circuit breaker was opened)
+func (l *circuitBreakerList) record(key string, success bool) (string, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.threshold <= 0 {
+		return CircuitBreakerClosed, 0
+	}
+
+	if success {
+		delete(l.targets, key)
+		return CircuitBreakerClosed, 0
+	}
+
+	breaker, exists := l.targets[key]
+	if !exists {
+		breaker = &circuitBreaker{state: CircuitBreakerClosed}
+		l.targets[key] = breaker
+	}
+	breaker.failures++
+
+	switch {
+	case breaker.state == CircuitBreakerHalfOpen:
+		breaker.cooldown = min(breaker.cooldown*2, l.cooldownMax)
+	case breaker.failures >= l.threshold:
+		breaker.cooldown = l.cooldown
+	default:
+		return breaker.state, 0
+	}
+
+	breaker.state = CircuitBreakerOpen
+	breaker.openUntil = time.Now().Add(breaker.cooldown)
+	return breaker.state, breaker.cooldown
+}
+
+// release resets a half open circuit breaker to open if the probe was cancelled (eg. scrape deadline)
+func (l *circuitBreakerList) release(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if breaker, exists := l.targets[key]; exists && breaker.state == CircuitBreakerHalfOpen {
+		breaker.state = CircuitBreakerOpen
+	}
+}
+
+// move moves the circuit breaker of the target to the new key (eg. device id is known now)
+func (l *circuitBreakerList) move(previousKey, key string) {
+	if previousKey == key {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if breaker, exists := l.targets[previousKey]; exists {
+		l.targets[key] = breaker
+		delete(l.targets, previousKey)
+	}
+}
+
+// circuitBreakerStateValue returns the metric value of the state (0 = closed, 1 = half open, 2 = open)
+func circuitBreakerStateValue(state string) float64 {
+	switch state {
+	case CircuitBreakerHalfOpen:
+		return 1
+	case CircuitBreakerOpen:
+		return 2
+	default:
+		return 0
+	}
+}
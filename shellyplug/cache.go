@@ -1,9 +1,18 @@
 package shellyplug
 
 import (
+	"sync"
+	"time"
+
 	cache "github.com/patrickmn/go-cache"
 )
 
+const (
+	// restyCacheExpiration is the lifetime of cached resty clients
+	restyCacheExpiration = 1 * time.Hour
+)
+
 var (
-	globalCache *cache.Cache
+	globalCache   *cache.Cache
+	cacheInitOnce sync.Once
 )
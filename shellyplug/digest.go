@@ -0,0 +1,118 @@
+package shellyplug
+
+import (
+	"crypto/md5" // #nosec G501 -- md5 is used by the digest authentication of older firmwares
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type (
+	// digestTransport answers digest authentication challenges (RFC 7616, gen2 devices are using SHA-256),
+	// the transport is set when the client is created so concurrent requests don't modify the client
+	digestTransport struct {
+		transport http.RoundTripper
+		username  string
+		password  string
+	}
+)
+
+func (t *digestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(strings.ToLower(challenge), "digest ") {
+		return resp, nil
+	}
+
+	authRequest := req.Clone(req.Context())
+	if req.Body != nil && req.GetBody != nil {
+		if authRequest.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+
+	authorization, err := t.authorization(req, parseDigestChallenge(challenge))
+	if err != nil {
+		return resp, nil
+	}
+
+	// response body has to be closed before the retry (releases the device slot)
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	authRequest.Header.Set("Authorization", authorization)
+	return t.transport.RoundTrip(authRequest)
+}
+
+// authorization builds the authorization header for the challenge (qop auth)
+func (t *digestTransport) authorization(req *http.Request, challenge map[string]string) (string, error) {
+	var hashFunc func() hash.Hash
+	algorithm := challenge["algorithm"]
+	switch strings.ToUpper(algorithm) {
+	case "", "MD5":
+		hashFunc = md5.New
+	case "SHA-256":
+		hashFunc = sha256.New
+	default:
+		return "", fmt.Errorf(`unsupported digest algorithm "%v"`, algorithm)
+	}
+
+	hashHex := func(val string) string {
+		h := hashFunc()
+		h.Write([]byte(val))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	cnonceBuf := make([]byte, 8)
+	if _, err := rand.Read(cnonceBuf); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(cnonceBuf)
+
+	uri := req.URL.RequestURI()
+	ha1 := hashHex(fmt.Sprintf("%s:%s:%s", t.username, challenge["realm"], t.password))
+	ha2 := hashHex(fmt.Sprintf("%s:%s", req.Method, uri))
+	response := hashHex(fmt.Sprintf("%s:%s:00000001:%s:auth:%s", ha1, challenge["nonce"], cnonce, ha2))
+
+	ret := fmt.Sprintf(
+		`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s", qop=auth, nc=00000001, cnonce="%s"`,
+		t.username, challenge["realm"], challenge["nonce"], uri, response, cnonce,
+	)
+	if algorithm != "" {
+		ret += fmt.Sprintf(`, algorithm=%s`, algorithm)
+	}
+	if opaque, ok := challenge["opaque"]; ok {
+		ret += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return ret, nil
+}
+
+// parseDigestChallenge parses the parameters of the WWW-Authenticate header (eg. Digest realm="x", nonce="y")
+func parseDigestChallenge(header string) map[string]string {
+	ret := map[string]string{}
+
+	_, params, _ := strings.Cut(header, " ")
+	for params != "" {
+		var key, value string
+		key, params, _ = strings.Cut(strings.TrimLeft(params, " ,"), "=")
+		if strings.HasPrefix(params, `"`) {
+			value, params, _ = strings.Cut(params[1:], `"`)
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+		}
+		if key = strings.ToLower(strings.TrimSpace(key)); key != "" {
+			ret[key] = strings.TrimSpace(value)
+		}
+	}
+
+	return ret
+}
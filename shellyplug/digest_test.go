@@ -0,0 +1,170 @@
+package shellyplug
+
+import (
+	"context"
+	"crypto/md5" // #nosec G501 -- md5 is used by the digest authentication of older firmwares
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevops/shelly-plug-exporter/discovery"
+)
+
+const (
+	testDigestUsername = "admin"
+	testDigestPassword = "secret"
+	testDigestRealm    = "shellyplus1pm-a8032ab1b1b0"
+	testDigestNonce    = "60dc59c6"
+)
+
+// testDigestServer is a device requiring digest authentication, the response is validated independently of digestTransport
+type testDigestServer struct {
+	algorithm string
+	opaque    string
+
+	// body of the last authenticated request
+	body string
+}
+
+func (s *testDigestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	if !s.authenticated(r) {
+		challenge := fmt.Sprintf(`Digest qop="auth", realm="%s", nonce="%s"`, testDigestRealm, testDigestNonce)
+		if s.algorithm != "" {
+			challenge += ", algorithm=" + s.algorithm
+		}
+		if s.opaque != "" {
+			challenge += fmt.Sprintf(`, opaque="%s"`, s.opaque)
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	s.body = string(body)
+	_, _ = w.Write([]byte(`{"ok":true}`))
+}
+
+func (s *testDigestServer) authenticated(r *http.Request) bool {
+	params := parseDigestChallenge(r.Header.Get("Authorization"))
+	if len(params) == 0 || params["username"] != testDigestUsername || params["nonce"] != testDigestNonce {
+		return false
+	}
+	if params["opaque"] != s.opaque {
+		return false
+	}
+
+	var hashFunc func() hash.Hash = md5.New
+	if s.algorithm == "SHA-256" {
+		hashFunc = sha256.New
+	}
+	hashHex := func(val string) string {
+		h := hashFunc()
+		h.Write([]byte(val))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	ha1 := hashHex(testDigestUsername + ":" + testDigestRealm + ":" + testDigestPassword)
+	ha2 := hashHex(r.Method + ":" + r.URL.RequestURI())
+	expected := hashHex(ha1 + ":" + testDigestNonce + ":" + params["nc"] + ":" + params["cnonce"] + ":" + params["qop"] + ":" + ha2)
+	return params["uri"] == r.URL.RequestURI() && params["response"] == expected
+}
+
+func testDigestTarget(t *testing.T, server *httptest.Server) discovery.DiscoveryTarget {
+	t.Helper()
+	host, rawPort, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(rawPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return discovery.DiscoveryTarget{
+		Hostname: host,
+		Address:  host,
+		Port:     port,
+		Type:     discovery.TargetTypeShellyPlus,
+		Static:   true,
+	}
+}
+
+func TestDigestAuth(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		opaque    string
+		password  string
+		wantErr   error
+	}{
+		{name: "md5 default", password: testDigestPassword},
+		{name: "md5", algorithm: "MD5", password: testDigestPassword},
+		{name: "sha-256", algorithm: "SHA-256", password: testDigestPassword},
+		{name: "opaque", algorithm: "SHA-256", opaque: "5ccc069c403ebaf9f0171e9517f40e41", password: testDigestPassword},
+		{name: "wrong credentials", algorithm: "SHA-256", password: "wrong", wantErr: ErrAuthenticationRequired},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := &testDigestServer{algorithm: test.algorithm, opaque: test.opaque}
+			server := httptest.NewServer(device)
+			defer server.Close()
+
+			sp := New(context.Background(), prometheus.NewRegistry(), slogger.NewDiscardLogger())
+			client := sp.restyClientWithAuth(
+				testDigestTarget(t, server),
+				restyAuth{scheme: restyAuthDigest, username: testDigestUsername, password: test.password},
+				slogger.NewDiscardLogger(),
+			)
+
+			res, err := client.R().Get("/rpc/Shelly.GetStatus?id=0")
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("expected error %v, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(res.Body()) != `{"ok":true}` {
+				t.Errorf("unexpected response %v", string(res.Body()))
+			}
+		})
+	}
+}
+
+func TestDigestAuthReplaysBody(t *testing.T) {
+	device := &testDigestServer{algorithm: "SHA-256"}
+	server := httptest.NewServer(device)
+	defer server.Close()
+
+	sp := New(context.Background(), prometheus.NewRegistry(), slogger.NewDiscardLogger())
+	client := sp.restyClientWithAuth(
+		testDigestTarget(t, server),
+		restyAuth{scheme: restyAuthDigest, username: testDigestUsername, password: testDigestPassword},
+		slogger.NewDiscardLogger(),
+	)
+
+	body := `{"id":1,"method":"Shelly.GetStatus"}`
+	if _, err := client.R().SetBody(body).Post("/rpc"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if device.body != body {
+		t.Errorf("expected body %v after authentication, got %v", body, device.body)
+	}
+}
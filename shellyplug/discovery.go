@@ -11,3 +11,10 @@ func (sp *ShellyPlug) UseDiscovery() {
 
 	sp.SetTargets(discovery.ServiceDiscovery.GetTargetList())
 }
+
+// markTargetUnhealthy marks the target as unhealthy, requests cancelled by the scrape deadline are ignored
+func (sp *ShellyPlug) markTargetUnhealthy(target discovery.DiscoveryTarget) {
+	if discovery.ServiceDiscovery != nil && sp.ctx.Err() == nil {
+		discovery.ServiceDiscovery.MarkTarget(target.Key(), discovery.TargetUnhealthy)
+	}
+}
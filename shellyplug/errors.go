@@ -0,0 +1,54 @@
+package shellyplug
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+)
+
+const (
+	ScrapeErrorClassTimeout    = "timeout"
+	ScrapeErrorClassAuth       = "auth"
+	ScrapeErrorClassHttpStatus = "http_status"
+	ScrapeErrorClassDecode     = "decode"
+	ScrapeErrorClassOther      = "other"
+)
+
+var (
+	ErrAuthenticationRequired = errors.New(`shelly plug requires authentication and/or credentials are invalid`)
+)
+
+type (
+	HttpStatusError struct {
+		StatusCode int
+	}
+)
+
+func (e *HttpStatusError) Error() string {
+	return fmt.Sprintf(`expected http status 200, got %v`, e.StatusCode)
+}
+
+// scrapeErrorClass returns the error class of a failed device request
+func scrapeErrorClass(err error) string {
+	var netErr net.Error
+	var httpStatusErr *HttpStatusError
+	var jsonSyntaxErr *json.SyntaxError
+	var jsonTypeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ScrapeErrorClassTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ScrapeErrorClassTimeout
+	case errors.Is(err, ErrAuthenticationRequired):
+		return ScrapeErrorClassAuth
+	case errors.As(err, &httpStatusErr):
+		return ScrapeErrorClassHttpStatus
+	case errors.As(err, &jsonSyntaxErr), errors.As(err, &jsonTypeErr):
+		return ScrapeErrorClassDecode
+	default:
+		return ScrapeErrorClassOther
+	}
+}
@@ -0,0 +1,115 @@
+package shellyplug
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	cache "github.com/patrickmn/go-cache"
+)
+
+var (
+	// targetSemaphore limits the number of devices probed at once (shared by all probes), nil if unlimited
+	targetSemaphore chan struct{}
+
+	// deviceRequestLimit is the limit of in-flight requests per device, 0 if unlimited
+	deviceRequestLimit int
+
+	// deviceSemaphores contains the semaphores of the devices, entries are refreshed whenever a client is built and
+	// expire after all cached clients using them (eg. changed device addresses or ad-hoc targets)
+	deviceSemaphores     *cache.Cache
+	deviceSemaphoresLock sync.Mutex
+)
+
+const (
+	// deviceSemaphoreGrace keeps semaphores after the client expired for probes still using the client
+	deviceSemaphoreGrace = 15 * time.Minute
+)
+
+type (
+	// deviceTransport limits the in-flight requests of one device (eg. gen1 devices are crashing under parallel requests)
+	deviceTransport struct {
+		transport http.RoundTripper
+		semaphore chan struct{}
+	}
+
+	// semaphoreBody releases the slot of the device when the response body is closed
+	semaphoreBody struct {
+		io.ReadCloser
+		semaphore chan struct{}
+		release   sync.Once
+	}
+)
+
+// SetConcurrencyLimits sets the number of devices probed at once and the number of in-flight requests per device (0 = unlimited)
+func SetConcurrencyLimits(maxTargets, maxRequestsPerDevice int) {
+	targetSemaphore = nil
+	if maxTargets > 0 {
+		targetSemaphore = make(chan struct{}, maxTargets)
+	}
+
+	deviceRequestLimit = maxRequestsPerDevice
+}
+
+// deviceSemaphore returns the semaphore of the device (see DiscoveryTarget.Key), nil if unlimited
+func deviceSemaphore(key string) chan struct{} {
+	if deviceRequestLimit <= 0 {
+		return nil
+	}
+
+	deviceSemaphoresLock.Lock()
+	defer deviceSemaphoresLock.Unlock()
+
+	semaphore := make(chan struct{}, deviceRequestLimit)
+	if val, exists := deviceSemaphores.Get(key); exists {
+		semaphore = val.(chan struct{})
+	}
+	deviceSemaphores.SetDefault(key, semaphore)
+	return semaphore
+}
+
+// acquireSemaphore waits for a free slot, nil semaphores are unlimited
+func acquireSemaphore(ctx context.Context, semaphore chan struct{}) error {
+	if semaphore == nil {
+		return nil
+	}
+
+	select {
+	case semaphore <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func releaseSemaphore(semaphore chan struct{}) {
+	if semaphore != nil {
+		<-semaphore
+	}
+}
+
+func (t *deviceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := acquireSemaphore(req.Context(), t.semaphore); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil || resp.Body == nil {
+		releaseSemaphore(t.semaphore)
+		return resp, err
+	}
+
+	// the request is in-flight until the response body is read, so the slot is released when the body is closed
+	resp.Body = &semaphoreBody{ReadCloser: resp.Body, semaphore: t.semaphore}
+	return resp, nil
+}
+
+func (b *semaphoreBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release.Do(func() {
+		releaseSemaphore(b.semaphore)
+	})
+	return err
+}
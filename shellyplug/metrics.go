@@ -2,13 +2,32 @@ package shellyplug
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/shelly-plug-exporter/discovery"
 )
 
 type (
 	shellyPlugMetrics struct {
+		up             *prometheus.GaugeVec
+		scrapeDuration *prometheus.GaugeVec
+		scrapeErrors   *prometheus.GaugeVec
+		scrapeSkipped  *prometheus.GaugeVec
+		circuitBreaker *prometheus.GaugeVec
+
 		info            *prometheus.GaugeVec
 		temp            *prometheus.GaugeVec
 		overTemp        *prometheus.GaugeVec
+		humidity        *prometheus.GaugeVec
+		illuminance     *prometheus.GaugeVec
+		voltmeter       *prometheus.GaugeVec
+		voltmeterValue  *prometheus.GaugeVec
+		batteryPercent  *prometheus.GaugeVec
+		batteryVoltage  *prometheus.GaugeVec
+		externalPower   *prometheus.GaugeVec
+		flood           *prometheus.GaugeVec
+		floodMute       *prometheus.GaugeVec
+		doorOpen        *prometheus.GaugeVec
+		lastSeen        *prometheus.GaugeVec
 		wifiRssi        *prometheus.GaugeVec
 		updateNeeded    *prometheus.GaugeVec
 		restartRequired *prometheus.GaugeVec
@@ -16,12 +35,32 @@ type (
 		cloudEnabled   *prometheus.GaugeVec
 		cloudConnected *prometheus.GaugeVec
 
-		switchOn        *prometheus.GaugeVec
-		switchOverpower *prometheus.GaugeVec
-		switchTimer     *prometheus.GaugeVec
+		switchOn           *prometheus.GaugeVec
+		switchOverpower    *prometheus.GaugeVec
+		switchOvervoltage  *prometheus.GaugeVec
+		switchUndervoltage *prometheus.GaugeVec
+		switchTimer        *prometheus.GaugeVec
+		switchOutputChange *prometheus.CounterVec
+
+		inputState          *prometheus.GaugeVec
+		inputAnalogPercent  *prometheus.GaugeVec
+		inputAnalogValue    *prometheus.GaugeVec
+		inputCounter        *prometheus.CounterVec
+		inputCounterValue   *prometheus.CounterVec
+		inputFrequency      *prometheus.GaugeVec
+		inputFrequencyValue *prometheus.GaugeVec
+
+		lightBrightness  *prometheus.GaugeVec
+		lightColor       *prometheus.GaugeVec
+		lightTemperature *prometheus.GaugeVec
+
+		coverState         *prometheus.GaugeVec
+		coverPosition      *prometheus.GaugeVec
+		coverLastDirection *prometheus.GaugeVec
 
 		powerLoadCurrent         *prometheus.GaugeVec
 		powerLoadApparentCurrent *prometheus.GaugeVec
+		powerLoadReactive        *prometheus.GaugeVec
 		powerLoadTotal           *prometheus.GaugeVec
 		powerLoadLimit           *prometheus.GaugeVec
 		powerFactor              *prometheus.GaugeVec
@@ -29,6 +68,12 @@ type (
 		powerVoltage             *prometheus.GaugeVec
 		powerAmpere              *prometheus.GaugeVec
 
+		emLoadCurrent         *prometheus.GaugeVec
+		emLoadApparentCurrent *prometheus.GaugeVec
+		emLoadTotal           *prometheus.GaugeVec
+		emAmpere              *prometheus.GaugeVec
+		emNeutralAmpere       *prometheus.GaugeVec
+
 		sysUnixtime *prometheus.GaugeVec
 		sysUptime   *prometheus.GaugeVec
 		sysMemTotal *prometheus.GaugeVec
@@ -38,11 +83,95 @@ type (
 	}
 )
 
+const (
+	TargetLabelAddress  = "address"
+	TargetLabelDeviceId = "deviceid"
+)
+
+var (
+	// customLabelNames are the names of the custom labels (config file)
+	customLabelNames []string
+
+	// targetLabel is the value of the target label (address or device id)
+	targetLabel = TargetLabelAddress
+)
+
+// SetCustomLabelNames sets the names of the custom labels (config file) which are added to shellyplug_info
+func SetCustomLabelNames(names []string) {
+	customLabelNames = names
+}
+
+// SetTargetLabel sets the value of the target label (address or deviceid)
+func SetTargetLabel(mode string) {
+	targetLabel = mode
+}
+
+// TargetLabel returns the value of the target label, the address is used until the device id is known
+func TargetLabel(target discovery.DiscoveryTarget) string {
+	if targetLabel == TargetLabelDeviceId && target.DeviceId != "" {
+		return target.DeviceId
+	}
+	return target.Address
+}
+
 func (sp *ShellyPlug) initMetrics() {
 	commonLabels := []string{"target", "mac", "plugName"}
 	tempLabels := append(commonLabels, "id", "name")
+	sensorLabels := append(commonLabels, "id", "name")
 	switchLabels := append(commonLabels, "id", "name")
 	powerLabels := append(commonLabels, "id", "name")
+	coverLabels := append(commonLabels, "id", "name")
+	lightLabels := append(commonLabels, "id", "name")
+	inputLabels := append(commonLabels, "id", "name")
+
+	// ##########################################
+	// Scrape health
+
+	sp.prometheus.up = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_up",
+			Help: "ShellyPlug status if device could be scraped successfully",
+		},
+		// only keyed by target so failed scrapes (unknown mac and name) are reported as the same series
+		[]string{"target"},
+	)
+	sp.registry.MustRegister(sp.prometheus.up)
+
+	sp.prometheus.scrapeDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_scrape_duration_seconds",
+			Help: "ShellyPlug duration of device requests in seconds",
+		},
+		[]string{"target", "endpoint"},
+	)
+	sp.registry.MustRegister(sp.prometheus.scrapeDuration)
+
+	sp.prometheus.scrapeErrors = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_scrape_errors",
+			Help: "ShellyPlug count of failed device requests by error class",
+		},
+		[]string{"target", "endpoint", "class"},
+	)
+	sp.registry.MustRegister(sp.prometheus.scrapeErrors)
+
+	sp.prometheus.scrapeSkipped = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_scrape_skipped",
+			Help: "ShellyPlug status if device was skipped because the concurrency limit was reached until the scrape deadline",
+		},
+		[]string{"target"},
+	)
+	sp.registry.MustRegister(sp.prometheus.scrapeSkipped)
+
+	sp.prometheus.circuitBreaker = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_circuit_breaker_state",
+			Help: "ShellyPlug circuit breaker state of the device (0 = closed, 1 = half open, 2 = open)",
+		},
+		[]string{"target"},
+	)
+	sp.registry.MustRegister(sp.prometheus.circuitBreaker)
 
 	// ##########################################
 	// Info
@@ -52,7 +181,7 @@ func (sp *ShellyPlug) initMetrics() {
 			Name: "shellyplug_info",
 			Help: "ShellyPlug info",
 		},
-		[]string{
+		append([]string{
 			"target",
 			"mac",
 			"hostname",
@@ -60,7 +189,7 @@ func (sp *ShellyPlug) initMetrics() {
 			"plugModel",
 			"plugApp",
 			"plugGeneration",
-		},
+		}, customLabelNames...),
 	)
 	sp.registry.MustRegister(sp.prometheus.info)
 
@@ -85,6 +214,108 @@ func (sp *ShellyPlug) initMetrics() {
 	)
 	sp.registry.MustRegister(sp.prometheus.overTemp)
 
+	// ##########################################
+	// Sensors
+
+	sp.prometheus.humidity = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_humidity",
+			Help: "ShellyPlug relative humidity in percent",
+		},
+		sensorLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.humidity)
+
+	sp.prometheus.illuminance = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_illuminance",
+			Help: "ShellyPlug illuminance in lux",
+		},
+		sensorLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.illuminance)
+
+	sp.prometheus.voltmeter = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_voltmeter_voltage",
+			Help: "ShellyPlug voltmeter voltage",
+		},
+		sensorLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.voltmeter)
+
+	sp.prometheus.voltmeterValue = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_voltmeter_value",
+			Help: "ShellyPlug voltmeter voltage transformed by the configured expression",
+		},
+		sensorLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.voltmeterValue)
+
+	sp.prometheus.batteryPercent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_battery_percent",
+			Help: "ShellyPlug battery level in percent",
+		},
+		sensorLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.batteryPercent)
+
+	sp.prometheus.batteryVoltage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_battery_voltage",
+			Help: "ShellyPlug battery voltage",
+		},
+		sensorLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.batteryVoltage)
+
+	sp.prometheus.externalPower = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_external_power",
+			Help: "ShellyPlug status if external power supply is present",
+		},
+		sensorLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.externalPower)
+
+	sp.prometheus.flood = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_flood",
+			Help: "ShellyPlug status if flood sensor detected water",
+		},
+		sensorLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.flood)
+
+	sp.prometheus.floodMute = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_flood_mute",
+			Help: "ShellyPlug status if the flood sensor alarm is muted",
+		},
+		sensorLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.floodMute)
+
+	sp.prometheus.doorOpen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_door_open",
+			Help: "ShellyPlug status if door/window sensor is open",
+		},
+		sensorLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.doorOpen)
+
+	sp.prometheus.lastSeen = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_last_seen_timestamp_seconds",
+			Help: "ShellyPlug timestamp of last reported device state (pushed devices)",
+		},
+		append(commonLabels, "source"),
+	)
+	sp.registry.MustRegister(sp.prometheus.lastSeen)
+
 	// ##########################################
 	// Wifi
 
@@ -160,6 +391,24 @@ func (sp *ShellyPlug) initMetrics() {
 	)
 	sp.registry.MustRegister(sp.prometheus.switchOverpower)
 
+	sp.prometheus.switchOvervoltage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_switch_overvoltage",
+			Help: "ShellyPlug switch overvoltage status",
+		},
+		switchLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.switchOvervoltage)
+
+	sp.prometheus.switchUndervoltage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_switch_undervoltage",
+			Help: "ShellyPlug switch undervoltage status",
+		},
+		switchLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.switchUndervoltage)
+
 	sp.prometheus.switchTimer = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "shellyplug_switch_timer",
@@ -169,6 +418,141 @@ func (sp *ShellyPlug) initMetrics() {
 	)
 	sp.registry.MustRegister(sp.prometheus.switchTimer)
 
+	sp.prometheus.switchOutputChange = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shellyplug_switch_output_changes_total",
+			Help: "ShellyPlug number of switch output changes seen via status notifications",
+		},
+		switchLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.switchOutputChange)
+
+	// ##########################################
+	// Input
+
+	sp.prometheus.inputState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_input_state",
+			Help: "ShellyPlug digital input state",
+		},
+		inputLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.inputState)
+
+	sp.prometheus.inputAnalogPercent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_input_analog_percent",
+			Help: "ShellyPlug analog input value in percent",
+		},
+		inputLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.inputAnalogPercent)
+
+	sp.prometheus.inputAnalogValue = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_input_analog_value",
+			Help: "ShellyPlug analog input value transformed by the configured expression",
+		},
+		inputLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.inputAnalogValue)
+
+	sp.prometheus.inputCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shellyplug_input_counter_total",
+			Help: "ShellyPlug input pulse/event counter",
+		},
+		inputLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.inputCounter)
+
+	sp.prometheus.inputCounterValue = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "shellyplug_input_counter_value_total",
+			Help: "ShellyPlug input pulse counter transformed by the configured expression",
+		},
+		inputLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.inputCounterValue)
+
+	sp.prometheus.inputFrequency = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_input_frequency",
+			Help: "ShellyPlug input pulse frequency in Hz",
+		},
+		inputLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.inputFrequency)
+
+	sp.prometheus.inputFrequencyValue = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_input_frequency_value",
+			Help: "ShellyPlug input pulse frequency transformed by the configured expression",
+		},
+		inputLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.inputFrequencyValue)
+
+	// ##########################################
+	// Light
+
+	sp.prometheus.lightBrightness = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_light_brightness",
+			Help: "ShellyPlug light brightness in percent",
+		},
+		lightLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.lightBrightness)
+
+	sp.prometheus.lightColor = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_light_color",
+			Help: "ShellyPlug light color channel value (0-255)",
+		},
+		append(lightLabels, "channel"),
+	)
+	sp.registry.MustRegister(sp.prometheus.lightColor)
+
+	sp.prometheus.lightTemperature = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_light_color_temperature",
+			Help: "ShellyPlug light white color temperature in kelvin",
+		},
+		lightLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.lightTemperature)
+
+	// ##########################################
+	// Cover
+
+	sp.prometheus.coverState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_cover_state",
+			Help: "ShellyPlug cover/roller current state",
+		},
+		append(coverLabels, "state"),
+	)
+	sp.registry.MustRegister(sp.prometheus.coverState)
+
+	sp.prometheus.coverPosition = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_cover_position",
+			Help: "ShellyPlug cover/roller current position in percent",
+		},
+		coverLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.coverPosition)
+
+	sp.prometheus.coverLastDirection = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_cover_last_direction",
+			Help: "ShellyPlug cover/roller last movement direction",
+		},
+		append(coverLabels, "direction"),
+	)
+	sp.registry.MustRegister(sp.prometheus.coverLastDirection)
+
 	// ##########################################
 	// Power
 
@@ -190,6 +574,15 @@ func (sp *ShellyPlug) initMetrics() {
 	)
 	sp.registry.MustRegister(sp.prometheus.powerLoadApparentCurrent)
 
+	sp.prometheus.powerLoadReactive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_power_load_reactive",
+			Help: "ShellyPlug current reactive power load in VAR",
+		},
+		powerLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.powerLoadReactive)
+
 	sp.prometheus.powerLoadTotal = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "shellyplug_power_load_total",
@@ -244,6 +637,54 @@ func (sp *ShellyPlug) initMetrics() {
 	)
 	sp.registry.MustRegister(sp.prometheus.powerAmpere)
 
+	// ##########################################
+	// Energy meter (device totals)
+
+	sp.prometheus.emLoadCurrent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_em_load_current",
+			Help: "ShellyPlug energy meter current power load of all phases in watts",
+		},
+		powerLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.emLoadCurrent)
+
+	sp.prometheus.emLoadApparentCurrent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_em_load_apparentcurrent",
+			Help: "ShellyPlug energy meter current apparent power load of all phases in VA",
+		},
+		powerLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.emLoadApparentCurrent)
+
+	sp.prometheus.emLoadTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_em_load_total",
+			Help: "ShellyPlug energy meter power load total of all phases in watt/hours",
+		},
+		append(powerLabels, "direction"),
+	)
+	sp.registry.MustRegister(sp.prometheus.emLoadTotal)
+
+	sp.prometheus.emAmpere = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_em_ampere",
+			Help: "ShellyPlug energy meter current of all phases in ampere",
+		},
+		powerLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.emAmpere)
+
+	sp.prometheus.emNeutralAmpere = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_em_neutral_ampere",
+			Help: "ShellyPlug energy meter neutral current in ampere",
+		},
+		powerLabels,
+	)
+	sp.registry.MustRegister(sp.prometheus.emNeutralAmpere)
+
 	// ##########################################
 	// System
 
@@ -19,3 +19,10 @@ func copyLabelMap(val prometheus.Labels) (ret prometheus.Labels) {
 	}
 	return
 }
+
+// addCustomLabels adds all custom labels (empty if not set for the target)
+func addCustomLabels(labels prometheus.Labels, values map[string]string) {
+	for _, name := range customLabelNames {
+		labels[name] = values[name]
+	}
+}
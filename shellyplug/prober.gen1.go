@@ -11,24 +11,28 @@ import (
 	"github.com/webdevops/shelly-plug-exporter/shellyprober"
 )
 
-func (sp *ShellyPlug) collectFromTargetGen1(target discovery.DiscoveryTarget, logger *slogger.Logger, infoLabels, targetLabels prometheus.Labels) {
-	client := sp.restyClient(sp.ctx, target, logger)
-	if sp.auth.username != "" {
-		client.SetDisableWarn(true)
-		client.SetBasicAuth(sp.auth.username, sp.auth.password)
+func (sp *ShellyPlug) collectFromTargetGen1(target discovery.DiscoveryTarget, logger *slogger.Logger, infoLabels, targetLabels prometheus.Labels) bool {
+	up := true
+
+	auth := restyAuth{}
+	if username, password := sp.targetAuth(target, targetLabels["mac"], logger); username != "" {
+		auth = restyAuth{scheme: restyAuthBasic, username: username, password: password}
 	}
+	client := sp.restyClientWithAuth(target, auth, logger)
 
 	shellyProber := shellyprober.ShellyProberGen1{
 		Target: target,
 		Client: client,
 		Ctx:    sp.ctx,
 		Cache:  globalCache,
+
+		RequestObserver: sp.requestObserver(target),
 	}
 
 	if result, err := shellyProber.GetSettings(); err == nil {
 		if discovery.ServiceDiscovery != nil {
-			discovery.ServiceDiscovery.MarkTarget(target.Address, discovery.TargetHealthy)
-			discovery.ServiceDiscovery.SetTargetDeviceName(target.Address, result.Name)
+			discovery.ServiceDiscovery.MarkTarget(target.Key(), discovery.TargetHealthy)
+			discovery.ServiceDiscovery.SetTargetDeviceName(target.Key(), result.Name)
 		}
 
 		targetLabels["plugName"] = result.Name
@@ -42,9 +46,8 @@ func (sp *ShellyPlug) collectFromTargetGen1(target discovery.DiscoveryTarget, lo
 		sp.prometheus.powerLoadLimit.With(powerLimitLabels).Set(result.MaxPower)
 	} else {
 		logger.Error(`failed to fetch settings`, slog.Any("error", err))
-		if discovery.ServiceDiscovery != nil {
-			discovery.ServiceDiscovery.MarkTarget(target.Address, discovery.TargetUnhealthy)
-		}
+		sp.markTargetUnhealthy(target)
+		up = false
 	}
 
 	sp.prometheus.info.With(infoLabels).Set(1)
@@ -60,8 +63,54 @@ func (sp *ShellyPlug) collectFromTargetGen1(target discovery.DiscoveryTarget, lo
 		tempLabels := copyLabelMap(targetLabels)
 		tempLabels["id"] = "sensor:0"
 		tempLabels["name"] = "system"
-		sp.prometheus.temp.With(tempLabels).Set(result.Temperature)
-		sp.prometheus.overTemp.With(tempLabels).Set(boolToFloat64(result.Overtemperature))
+		switch {
+		case result.Temperature != nil:
+			sp.prometheus.temp.With(tempLabels).Set(*result.Temperature)
+			sp.prometheus.overTemp.With(tempLabels).Set(boolToFloat64(result.Overtemperature))
+		case result.Tmp.IsValid:
+			// sensor devices (eg. H&T) are only reporting tmp
+			sp.prometheus.temp.With(tempLabels).Set(result.Tmp.TC)
+		}
+
+		sensorLabels := copyLabelMap(tempLabels)
+		if result.Hum.IsValid {
+			sp.prometheus.humidity.With(sensorLabels).Set(result.Hum.Value)
+		}
+
+		if result.Lux.IsValid {
+			sp.prometheus.illuminance.With(sensorLabels).Set(result.Lux.Value)
+		}
+
+		if result.Bat != nil {
+			sp.prometheus.batteryPercent.With(sensorLabels).Set(result.Bat.Value)
+			sp.prometheus.batteryVoltage.With(sensorLabels).Set(result.Bat.Voltage)
+		}
+
+		if result.Charger != nil {
+			sp.prometheus.externalPower.With(sensorLabels).Set(boolToFloat64(*result.Charger))
+		}
+
+		// addon sensors
+		for sensorID, sensor := range result.ExtTemperature {
+			sensorLabels := copyLabelMap(targetLabels)
+			sensorLabels["id"] = fmt.Sprintf("ext_temperature:%s", sensorID)
+			sensorLabels["name"] = sensor.HwID
+			sp.prometheus.temp.With(sensorLabels).Set(sensor.TC)
+		}
+
+		for sensorID, sensor := range result.ExtHumidity {
+			sensorLabels := copyLabelMap(targetLabels)
+			sensorLabels["id"] = fmt.Sprintf("ext_humidity:%s", sensorID)
+			sensorLabels["name"] = sensor.HwID
+			sp.prometheus.humidity.With(sensorLabels).Set(sensor.Hum)
+		}
+
+		for adcID, adc := range result.Adcs {
+			sensorLabels := copyLabelMap(targetLabels)
+			sensorLabels["id"] = fmt.Sprintf("adc:%d", adcID)
+			sensorLabels["name"] = targetLabels["plugName"]
+			sp.prometheus.voltmeter.With(sensorLabels).Set(adc.Voltage)
+		}
 
 		wifiLabels := copyLabelMap(targetLabels)
 		wifiLabels["ssid"] = result.WifiSta.Ssid
@@ -82,6 +131,121 @@ func (sp *ShellyPlug) collectFromTargetGen1(target discovery.DiscoveryTarget, lo
 			sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(powerUsage.Total / 60)
 		}
 
+		for emeterID, emeter := range result.Emeters {
+			if !emeter.IsValid {
+				continue
+			}
+
+			powerUsageLabels := copyLabelMap(targetLabels)
+			powerUsageLabels["id"] = fmt.Sprintf("emeter:%d", emeterID)
+			powerUsageLabels["name"] = targetLabels["plugName"]
+
+			sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(emeter.Power)
+			sp.prometheus.powerLoadReactive.With(powerUsageLabels).Set(emeter.Reactive)
+			sp.prometheus.powerVoltage.With(powerUsageLabels).Set(emeter.Voltage)
+			if emeter.Pf != nil {
+				sp.prometheus.powerFactor.With(powerUsageLabels).Set(*emeter.Pf)
+			}
+			if emeter.Current != nil {
+				sp.prometheus.powerAmpere.With(powerUsageLabels).Set(*emeter.Current)
+			}
+
+			// total is provided as watt/hours
+			powerUsageLabels["direction"] = "in"
+			sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(emeter.Total)
+
+			powerUsageLabels["direction"] = "out"
+			sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(emeter.TotalReturned)
+		}
+
+		for inputID, input := range result.Inputs {
+			inputLabels := copyLabelMap(targetLabels)
+			inputLabels["id"] = fmt.Sprintf("input:%d", inputID)
+			inputLabels["name"] = targetLabels["plugName"]
+
+			sp.prometheus.inputState.With(inputLabels).Set(float64(input.Input))
+			sp.prometheus.inputCounter.With(inputLabels).Add(float64(input.EventCnt))
+		}
+
+		for lightID, light := range result.Lights {
+			switchLabels := copyLabelMap(targetLabels)
+			switchLabels["id"] = fmt.Sprintf("light:%d", lightID)
+			switchLabels["name"] = targetLabels["plugName"]
+
+			switchOnLabels := copyLabelMap(switchLabels)
+			switchOnLabels["source"] = light.Source
+
+			sp.prometheus.switchOn.With(switchOnLabels).Set(boolToFloat64(light.Ison))
+			sp.prometheus.switchOverpower.With(switchLabels).Set(boolToFloat64(light.Overpower))
+			sp.prometheus.switchTimer.With(switchLabels).Set(boolToFloat64(light.HasTimer))
+
+			lightLabels := copyLabelMap(switchLabels)
+			switch {
+			case light.Brightness != nil:
+				sp.prometheus.lightBrightness.With(lightLabels).Set(float64(*light.Brightness))
+			case light.Gain != nil:
+				// rgbw devices in color mode are using gain as brightness
+				sp.prometheus.lightBrightness.With(lightLabels).Set(float64(*light.Gain))
+			}
+
+			if light.Temp != nil {
+				sp.prometheus.lightTemperature.With(lightLabels).Set(float64(*light.Temp))
+			}
+
+			for channel, value := range map[string]*int{"red": light.Red, "green": light.Green, "blue": light.Blue, "white": light.White} {
+				if value != nil {
+					lightColorLabels := copyLabelMap(lightLabels)
+					lightColorLabels["channel"] = channel
+					sp.prometheus.lightColor.With(lightColorLabels).Set(float64(*value))
+				}
+			}
+		}
+
+		for rollerID, roller := range result.Rollers {
+			coverLabels := copyLabelMap(targetLabels)
+			coverLabels["id"] = fmt.Sprintf("roller:%d", rollerID)
+			coverLabels["name"] = targetLabels["plugName"]
+
+			coverStateLabels := copyLabelMap(coverLabels)
+			coverStateLabels["state"] = roller.State
+			sp.prometheus.coverState.With(coverStateLabels).Set(1)
+
+			// position is only available if roller is calibrated
+			if roller.Positioning {
+				sp.prometheus.coverPosition.With(coverLabels).Set(float64(roller.CurrentPos))
+			}
+
+			if roller.LastDirection != "" {
+				coverDirectionLabels := copyLabelMap(coverLabels)
+				coverDirectionLabels["direction"] = roller.LastDirection
+				sp.prometheus.coverLastDirection.With(coverDirectionLabels).Set(1)
+			}
+
+			if result.Temperature != nil {
+				sp.prometheus.temp.With(coverLabels).Set(*result.Temperature)
+			}
+			sp.prometheus.overTemp.With(coverLabels).Set(boolToFloat64(roller.Overtemperature))
+
+			// current is not reported by gen1 rollers
+			powerUsageLabels := copyLabelMap(coverLabels)
+			if roller.IsValid {
+				sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(roller.Power)
+			}
+			if result.Voltage != nil {
+				sp.prometheus.powerVoltage.With(powerUsageLabels).Set(*result.Voltage)
+			}
+
+			// each roller is using two meters (open and close), total is provided as watt/minutes, we want watt/hours
+			if meters := result.Meters[min(rollerID*2, len(result.Meters)):min(rollerID*2+2, len(result.Meters))]; len(meters) > 0 {
+				total := 0.0
+				for _, meter := range meters {
+					total += meter.Total
+				}
+				powerUsageLabels["direction"] = "in"
+				sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(total / 60)
+			}
+		}
+
 		for relayID, relay := range result.Relays {
 			switchLabels := copyLabelMap(targetLabels)
 			switchLabels["id"] = fmt.Sprintf("relay:%d", relayID)
@@ -96,8 +260,9 @@ func (sp *ShellyPlug) collectFromTargetGen1(target discovery.DiscoveryTarget, lo
 		}
 	} else {
 		logger.Error(`failed to fetch status`, slog.Any("error", err))
-		if discovery.ServiceDiscovery != nil {
-			discovery.ServiceDiscovery.MarkTarget(target.Address, discovery.TargetUnhealthy)
-		}
+		sp.markTargetUnhealthy(target)
+		up = false
 	}
+
+	return up
 }
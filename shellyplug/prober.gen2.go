@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/webdevops/shelly-plug-exporter/discovery"
 	"github.com/webdevops/shelly-plug-exporter/shellyprober"
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
 )
 
 type (
@@ -20,193 +22,576 @@ type (
 	}
 )
 
-func (sp *ShellyPlug) collectFromTargetGen2(target discovery.DiscoveryTarget, logger *slogger.Logger, infoLabels, targetLabels prometheus.Labels) {
+func (sp *ShellyPlug) collectFromTargetGen2(target discovery.DiscoveryTarget, logger *slogger.Logger, infoLabels, targetLabels prometheus.Labels) bool {
+	up := true
 	sp.prometheus.info.With(infoLabels).Set(1)
 
-	client := sp.restyClient(sp.ctx, target, logger)
-	if sp.auth.username != "" {
-		client.SetDisableWarn(true)
-		client.SetDigestAuth(sp.auth.username, sp.auth.password)
+	auth := restyAuth{}
+	if username, password := sp.targetAuth(target, targetLabels["mac"], logger); password != "" {
+		if username == "" {
+			// gen2 devices are always using admin as username
+			username = "admin"
+		}
+		auth = restyAuth{scheme: restyAuthDigest, username: username, password: password}
 	}
+	client := sp.restyClientWithAuth(target, auth, logger)
 
 	shellyProber := shellyprober.ShellyProberGen2{
 		Target: target,
 		Client: client,
 		Ctx:    sp.ctx,
 		Cache:  globalCache,
+
+		RequestObserver: sp.requestObserver(target),
 	}
 
 	if shellyConfig, err := shellyProber.GetShellyConfig(); err == nil {
 		// target is healthy
 		if discovery.ServiceDiscovery != nil {
-			discovery.ServiceDiscovery.MarkTarget(target.Address, discovery.TargetHealthy)
-			discovery.ServiceDiscovery.SetTargetDeviceName(target.Address, infoLabels["plugName"])
+			discovery.ServiceDiscovery.MarkTarget(target.Key(), discovery.TargetHealthy)
+			discovery.ServiceDiscovery.SetTargetDeviceName(target.Key(), infoLabels["plugName"])
 		}
 
-		// systemStatus
-		if result, err := shellyProber.GetSysStatus(); err == nil {
-			sp.prometheus.sysUnixtime.With(targetLabels).Set(float64(result.Unixtime))
-			sp.prometheus.sysUptime.With(targetLabels).Set(float64(result.Uptime))
-			sp.prometheus.sysMemTotal.With(targetLabels).Set(float64(result.RAMSize))
-			sp.prometheus.sysMemFree.With(targetLabels).Set(float64(result.RAMFree))
-			sp.prometheus.sysFsSize.With(targetLabels).Set(float64(result.FsSize))
-			sp.prometheus.sysFsFree.With(targetLabels).Set(float64(result.FsFree))
-			sp.prometheus.restartRequired.With(targetLabels).Set(boolToFloat64(result.RestartRequired))
-
-			if result.AvailableUpdates.Stable.Version != "" {
-				sp.prometheus.updateNeeded.With(targetLabels).Set(1)
-			} else {
-				sp.prometheus.updateNeeded.With(targetLabels).Set(0)
-			}
-		} else {
-			logger.Error(`failed to decode sysConfig`, slog.Any("error", err))
+		if device, ok := sp.getMirroredDevice(target); ok {
+			// status is mirrored via websocket notifications
+			logger.Debug(`using mirrored shellyStatus`, slog.String("source", device.Source))
+			shellyProber.Status = device.Components
+			sp.collectOutputChanges(device, shellyConfig, targetLabels)
+		} else if err := shellyProber.LoadShellyStatus(); err != nil {
+			// fetch status of all components with one request
+			logger.Warn(`failed to fetch shellyStatus, falling back to component requests`, slog.Any("error", err))
 		}
 
-		// wifiStatus
-		if result, err := shellyProber.GetWifiStatus(); err == nil {
-			wifiLabels := copyLabelMap(targetLabels)
-			wifiLabels["ssid"] = result.Ssid
-			sp.prometheus.wifiRssi.With(wifiLabels).Set(float64(result.Rssi))
+		up = sp.collectGen2Status(&shellyProber, shellyConfig, logger, targetLabels)
+	} else {
+		logger.Error(`failed to fetch status`, slog.Any("error", err))
+		sp.markTargetUnhealthy(target)
+		up = false
+	}
+
+	return up
+}
+
+func (sp *ShellyPlug) getMirroredDevice(target discovery.DiscoveryTarget) (shellystate.Device, bool) {
+	if sp.stateMirror == nil {
+		return shellystate.Device{}, false
+	}
+	return sp.stateMirror.GetDevice(target.Key())
+}
+
+// collectGen2Status collects the metrics of all configured components
+func (sp *ShellyPlug) collectGen2Status(shellyProber *shellyprober.ShellyProberGen2, shellyConfig shellyprober.ShellyProberGen2ResultShellyConfig, logger *slogger.Logger, targetLabels prometheus.Labels) bool {
+	up := true
+
+	// systemStatus
+	if result, err := shellyProber.GetSysStatus(); err == nil {
+		sp.prometheus.sysUnixtime.With(targetLabels).Set(float64(result.Unixtime))
+		sp.prometheus.sysUptime.With(targetLabels).Set(float64(result.Uptime))
+		sp.prometheus.sysMemTotal.With(targetLabels).Set(float64(result.RAMSize))
+		sp.prometheus.sysMemFree.With(targetLabels).Set(float64(result.RAMFree))
+		sp.prometheus.sysFsSize.With(targetLabels).Set(float64(result.FsSize))
+		sp.prometheus.sysFsFree.With(targetLabels).Set(float64(result.FsFree))
+		sp.prometheus.restartRequired.With(targetLabels).Set(boolToFloat64(result.RestartRequired))
+
+		if result.AvailableUpdates.Stable.Version != "" {
+			sp.prometheus.updateNeeded.With(targetLabels).Set(1)
 		} else {
-			logger.Error(`failed to decode wifiStatus`, slog.Any("error", err))
+			sp.prometheus.updateNeeded.With(targetLabels).Set(0)
 		}
+	} else if !isStatusOnlyError(err) {
+		logger.Error(`failed to decode sysConfig`, slog.Any("error", err))
+		up = false
+	}
 
-		for configName, configValue := range shellyConfig {
-			switch {
-			// switch
-			case strings.HasPrefix(configName, "switch:"):
-				if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
-					if result, err := shellyProber.GetSwitchStatus(configData.Id); err == nil {
-						switchLabels := copyLabelMap(targetLabels)
-						switchLabels["id"] = fmt.Sprintf("switch:%d", configData.Id)
-						switchLabels["name"] = configData.Name
-
-						switchOnLabels := copyLabelMap(switchLabels)
-						switchOnLabels["source"] = result.Source
-
-						sp.prometheus.switchOn.With(switchOnLabels).Set(boolToFloat64(result.Output))
-
-						powerUsageLabels := copyLabelMap(targetLabels)
-						powerUsageLabels["id"] = fmt.Sprintf("switch:%d", configData.Id)
-						powerUsageLabels["name"] = configData.Name
-						sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(result.Apower)
-						sp.prometheus.powerVoltage.With(powerUsageLabels).Set(result.Voltage)
-						sp.prometheus.powerAmpere.With(powerUsageLabels).Set(result.Current)
-					} else {
-						logger.Error(`failed to decode switchStatus`, slog.Any("error", err))
-					}
-				}
-			// em
-			case strings.HasPrefix(configName, "em:"):
-				if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
-					if result, err := shellyProber.GetEmStatus(configData.Id); err == nil {
-						// phase A
-						phase := "A"
-						powerUsageLabels := copyLabelMap(targetLabels)
-						powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
-						powerUsageLabels["name"] = configData.Name
-						sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(result.AActPower)
-						sp.prometheus.powerLoadApparentCurrent.With(powerUsageLabels).Set(result.AAprtPower)
-						sp.prometheus.powerFactor.With(powerUsageLabels).Set(result.APf)
-						sp.prometheus.powerFrequency.With(powerUsageLabels).Set(result.AFreq)
-						sp.prometheus.powerVoltage.With(powerUsageLabels).Set(result.AVoltage)
-						sp.prometheus.powerAmpere.With(powerUsageLabels).Set(result.ACurrent)
-
-						// phase B
-						phase = "B"
-						powerUsageLabels = copyLabelMap(targetLabels)
-						powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
-						powerUsageLabels["name"] = configData.Name
-						sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(result.BActPower)
-						sp.prometheus.powerLoadApparentCurrent.With(powerUsageLabels).Set(result.BAprtPower)
-						sp.prometheus.powerFactor.With(powerUsageLabels).Set(result.BPf)
-						sp.prometheus.powerFrequency.With(powerUsageLabels).Set(result.BFreq)
-						sp.prometheus.powerVoltage.With(powerUsageLabels).Set(result.BVoltage)
-						sp.prometheus.powerAmpere.With(powerUsageLabels).Set(result.BCurrent)
-
-						// phase C
-						phase = "C"
-						powerUsageLabels = copyLabelMap(targetLabels)
-						powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
-						powerUsageLabels["name"] = configData.Name
-						sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(result.CActPower)
-						sp.prometheus.powerLoadApparentCurrent.With(powerUsageLabels).Set(result.CAprtPower)
-						sp.prometheus.powerFactor.With(powerUsageLabels).Set(result.CPf)
-						sp.prometheus.powerFrequency.With(powerUsageLabels).Set(result.CFreq)
-						sp.prometheus.powerVoltage.With(powerUsageLabels).Set(result.CVoltage)
-						sp.prometheus.powerAmpere.With(powerUsageLabels).Set(result.CCurrent)
-					} else {
-						logger.Error(`failed to decode switchStatus`, slog.Any("error", err))
-					}
-
-					if result, err := shellyProber.GetEmDataStatus(configData.Id); err == nil {
-						// phase A
-						phase := "A"
-						powerUsageLabels := copyLabelMap(targetLabels)
-						powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
-						powerUsageLabels["name"] = configData.Name
-						powerUsageLabels["direction"] = "in"
-						sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.ATotalActEnergy)
-
-						powerUsageLabels = copyLabelMap(targetLabels)
-						powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
-						powerUsageLabels["name"] = configData.Name
-						powerUsageLabels["direction"] = "out"
-						sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.ATotalActRetEnergy)
-
-						// phase B
-						phase = "B"
-						powerUsageLabels = copyLabelMap(targetLabels)
-						powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
-						powerUsageLabels["name"] = configData.Name
-						powerUsageLabels["direction"] = "in"
-						sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.BTotalActEnergy)
-
-						powerUsageLabels = copyLabelMap(targetLabels)
-						powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
-						powerUsageLabels["name"] = configData.Name
+	// wifiStatus
+	if result, err := shellyProber.GetWifiStatus(); err == nil {
+		wifiLabels := copyLabelMap(targetLabels)
+		wifiLabels["ssid"] = result.Ssid
+		sp.prometheus.wifiRssi.With(wifiLabels).Set(float64(result.Rssi))
+	} else if !isStatusOnlyError(err) {
+		logger.Error(`failed to decode wifiStatus`, slog.Any("error", err))
+	}
+
+	for configName, configValue := range shellyConfig {
+		switch {
+		// switch
+		case strings.HasPrefix(configName, "switch:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetSwitchStatus(configData.Id); err == nil {
+					switchLabels := copyLabelMap(targetLabels)
+					switchLabels["id"] = fmt.Sprintf("switch:%d", configData.Id)
+					switchLabels["name"] = configData.Name
+
+					switchOnLabels := copyLabelMap(switchLabels)
+					switchOnLabels["source"] = result.Source
+
+					sp.prometheus.switchOn.With(switchOnLabels).Set(boolToFloat64(result.Output))
+					sp.prometheus.switchOverpower.With(switchLabels).Set(boolToFloat64(slices.Contains(result.Errors, "overpower")))
+					sp.prometheus.switchOvervoltage.With(switchLabels).Set(boolToFloat64(slices.Contains(result.Errors, "overvoltage")))
+					sp.prometheus.switchUndervoltage.With(switchLabels).Set(boolToFloat64(slices.Contains(result.Errors, "undervoltage")))
+
+					if result.Temperature.TC != nil {
+						tempLabels := copyLabelMap(switchLabels)
+						sp.prometheus.temp.With(tempLabels).Set(*result.Temperature.TC)
+						sp.prometheus.overTemp.With(tempLabels).Set(boolToFloat64(slices.Contains(result.Errors, "overtemp")))
+					}
+
+					powerUsageLabels := copyLabelMap(targetLabels)
+					powerUsageLabels["id"] = fmt.Sprintf("switch:%d", configData.Id)
+					powerUsageLabels["name"] = configData.Name
+					sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(result.Apower)
+					sp.prometheus.powerVoltage.With(powerUsageLabels).Set(result.Voltage)
+					sp.prometheus.powerAmpere.With(powerUsageLabels).Set(result.Current)
+
+					// total is provided as watt/hours
+					powerUsageLabels["direction"] = "in"
+					sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.Aenergy.Total)
+
+					if result.RetAenergy != nil {
 						powerUsageLabels["direction"] = "out"
-						sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.BTotalActRetEnergy)
-
-						// phase C
-						phase = "C"
-						powerUsageLabels = copyLabelMap(targetLabels)
-						powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
-						powerUsageLabels["name"] = configData.Name
-						powerUsageLabels["direction"] = "in"
-						sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.CTotalActEnergy)
-
-						powerUsageLabels = copyLabelMap(targetLabels)
-						powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
-						powerUsageLabels["name"] = configData.Name
+						sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.RetAenergy.Total)
+					}
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode switchStatus`, slog.Any("error", err))
+				}
+			}
+		// input
+		case strings.HasPrefix(configName, "input:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetInputStatus(configData.Id); err == nil {
+					inputLabels := copyLabelMap(targetLabels)
+					inputLabels["id"] = fmt.Sprintf("input:%d", configData.Id)
+					inputLabels["name"] = configData.Name
+
+					// available values depend on the input type (button, switch, analog or count)
+					if result.State != nil {
+						sp.prometheus.inputState.With(inputLabels).Set(boolToFloat64(*result.State))
+					}
+					if result.Percent != nil {
+						sp.prometheus.inputAnalogPercent.With(inputLabels).Set(*result.Percent)
+					}
+					if result.Xpercent != nil {
+						sp.prometheus.inputAnalogValue.With(inputLabels).Set(*result.Xpercent)
+					}
+					if result.Counts != nil {
+						sp.prometheus.inputCounter.With(inputLabels).Add(result.Counts.Total)
+						// transformed value could be negative which is not allowed for counters
+						if result.Counts.Xtotal != nil && *result.Counts.Xtotal >= 0 {
+							sp.prometheus.inputCounterValue.With(inputLabels).Add(*result.Counts.Xtotal)
+						}
+					}
+					if result.Freq != nil {
+						sp.prometheus.inputFrequency.With(inputLabels).Set(*result.Freq)
+					}
+					if result.Xfreq != nil {
+						sp.prometheus.inputFrequencyValue.With(inputLabels).Set(*result.Xfreq)
+					}
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode inputStatus`, slog.Any("error", err))
+				}
+			}
+
+		// light
+		case strings.HasPrefix(configName, "light:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetLightStatus(configData.Id); err == nil {
+					sp.collectGen2Light(fmt.Sprintf("light:%d", configData.Id), configData.Name, result, targetLabels)
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode lightStatus`, slog.Any("error", err))
+				}
+			}
+
+		// rgb
+		case strings.HasPrefix(configName, "rgb:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetRgbStatus(configData.Id); err == nil {
+					sp.collectGen2Light(fmt.Sprintf("rgb:%d", configData.Id), configData.Name, result, targetLabels)
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode rgbStatus`, slog.Any("error", err))
+				}
+			}
+
+		// rgbw
+		case strings.HasPrefix(configName, "rgbw:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetRgbwStatus(configData.Id); err == nil {
+					sp.collectGen2Light(fmt.Sprintf("rgbw:%d", configData.Id), configData.Name, result, targetLabels)
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode rgbwStatus`, slog.Any("error", err))
+				}
+			}
+
+		// cover
+		case strings.HasPrefix(configName, "cover:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetCoverStatus(configData.Id); err == nil {
+					coverLabels := copyLabelMap(targetLabels)
+					coverLabels["id"] = fmt.Sprintf("cover:%d", configData.Id)
+					coverLabels["name"] = configData.Name
+
+					coverStateLabels := copyLabelMap(coverLabels)
+					coverStateLabels["state"] = result.State
+					sp.prometheus.coverState.With(coverStateLabels).Set(1)
+
+					// position is only available if cover is calibrated
+					if result.PosControl && result.CurrentPos != nil {
+						sp.prometheus.coverPosition.With(coverLabels).Set(*result.CurrentPos)
+					}
+
+					if result.LastDirection != nil {
+						coverDirectionLabels := copyLabelMap(coverLabels)
+						coverDirectionLabels["direction"] = *result.LastDirection
+						sp.prometheus.coverLastDirection.With(coverDirectionLabels).Set(1)
+					}
+
+					if result.Temperature.TC != nil {
+						sp.prometheus.temp.With(coverLabels).Set(*result.Temperature.TC)
+						sp.prometheus.overTemp.With(coverLabels).Set(boolToFloat64(slices.Contains(result.Errors, "overtemp")))
+					}
+
+					powerUsageLabels := copyLabelMap(coverLabels)
+					sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(result.Apower)
+					sp.prometheus.powerFactor.With(powerUsageLabels).Set(result.Pf)
+					sp.prometheus.powerFrequency.With(powerUsageLabels).Set(result.Freq)
+					sp.prometheus.powerVoltage.With(powerUsageLabels).Set(result.Voltage)
+					sp.prometheus.powerAmpere.With(powerUsageLabels).Set(result.Current)
+
+					// total is provided as watt/hours
+					powerUsageLabels["direction"] = "in"
+					sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.Aenergy.Total)
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode coverStatus`, slog.Any("error", err))
+				}
+			}
+
+		// pm1 (power meter, eg. gen3 pm mini)
+		case strings.HasPrefix(configName, "pm1:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetPm1Status(configData.Id); err == nil {
+					powerUsageLabels := copyLabelMap(targetLabels)
+					powerUsageLabels["id"] = fmt.Sprintf("pm1:%d", configData.Id)
+					powerUsageLabels["name"] = configData.Name
+					sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(result.Apower)
+					sp.prometheus.powerLoadApparentCurrent.With(powerUsageLabels).Set(result.Aprtpower)
+					sp.prometheus.powerFactor.With(powerUsageLabels).Set(result.Pf)
+					sp.prometheus.powerFrequency.With(powerUsageLabels).Set(result.Freq)
+					sp.prometheus.powerVoltage.With(powerUsageLabels).Set(result.Voltage)
+					sp.prometheus.powerAmpere.With(powerUsageLabels).Set(result.Current)
+
+					powerUsageLabels["direction"] = "in"
+					sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.Aenergy.Total)
+
+					if result.RetAenergy != nil {
 						powerUsageLabels["direction"] = "out"
-						sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.CTotalActRetEnergy)
-					} else {
-						logger.Error(`failed to decode switchStatus`, slog.Any("error", err))
+						sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.RetAenergy.Total)
 					}
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode pm1Status`, slog.Any("error", err))
 				}
+			}
+
+		// em
+		case strings.HasPrefix(configName, "em:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetEmStatus(configData.Id); err == nil {
+					// phase A
+					phase := "A"
+					powerUsageLabels := copyLabelMap(targetLabels)
+					powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
+					powerUsageLabels["name"] = configData.Name
+					sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(result.AActPower)
+					sp.prometheus.powerLoadApparentCurrent.With(powerUsageLabels).Set(result.AAprtPower)
+					sp.prometheus.powerFactor.With(powerUsageLabels).Set(result.APf)
+					sp.prometheus.powerFrequency.With(powerUsageLabels).Set(result.AFreq)
+					sp.prometheus.powerVoltage.With(powerUsageLabels).Set(result.AVoltage)
+					sp.prometheus.powerAmpere.With(powerUsageLabels).Set(result.ACurrent)
 
-			// temperatureSensor
-			case strings.HasPrefix(configName, "temperature:"):
-				if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
-					if result, err := shellyProber.GetTemperatureStatus(configData.Id); err == nil {
-						tempLabels := copyLabelMap(targetLabels)
-						tempLabels["id"] = fmt.Sprintf("sensor:%d", configData.Id)
-						tempLabels["name"] = configData.Name
+					// phase B
+					phase = "B"
+					powerUsageLabels = copyLabelMap(targetLabels)
+					powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
+					powerUsageLabels["name"] = configData.Name
+					sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(result.BActPower)
+					sp.prometheus.powerLoadApparentCurrent.With(powerUsageLabels).Set(result.BAprtPower)
+					sp.prometheus.powerFactor.With(powerUsageLabels).Set(result.BPf)
+					sp.prometheus.powerFrequency.With(powerUsageLabels).Set(result.BFreq)
+					sp.prometheus.powerVoltage.With(powerUsageLabels).Set(result.BVoltage)
+					sp.prometheus.powerAmpere.With(powerUsageLabels).Set(result.BCurrent)
 
-						sp.prometheus.temp.With(tempLabels).Set(result.TC)
-					} else {
-						logger.Error(`failed to decode temperatureStatus`, slog.Any("error", err))
+					// phase C
+					phase = "C"
+					powerUsageLabels = copyLabelMap(targetLabels)
+					powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
+					powerUsageLabels["name"] = configData.Name
+					sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(result.CActPower)
+					sp.prometheus.powerLoadApparentCurrent.With(powerUsageLabels).Set(result.CAprtPower)
+					sp.prometheus.powerFactor.With(powerUsageLabels).Set(result.CPf)
+					sp.prometheus.powerFrequency.With(powerUsageLabels).Set(result.CFreq)
+					sp.prometheus.powerVoltage.With(powerUsageLabels).Set(result.CVoltage)
+					sp.prometheus.powerAmpere.With(powerUsageLabels).Set(result.CCurrent)
+
+					// device totals
+					powerUsageLabels = copyLabelMap(targetLabels)
+					powerUsageLabels["id"] = fmt.Sprintf("em:%d", configData.Id)
+					powerUsageLabels["name"] = configData.Name
+					sp.prometheus.emLoadCurrent.With(powerUsageLabels).Set(result.TotalActPower)
+					sp.prometheus.emLoadApparentCurrent.With(powerUsageLabels).Set(result.TotalAprtPower)
+					sp.prometheus.emAmpere.With(powerUsageLabels).Set(result.TotalCurrent)
+
+					// neutral current is only available if measured
+					if val, ok := result.NCurrent.(float64); ok {
+						sp.prometheus.emNeutralAmpere.With(powerUsageLabels).Set(val)
 					}
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode switchStatus`, slog.Any("error", err))
+				}
+
+				if result, err := shellyProber.GetEmDataStatus(configData.Id); err == nil {
+					// phase A
+					phase := "A"
+					powerUsageLabels := copyLabelMap(targetLabels)
+					powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
+					powerUsageLabels["name"] = configData.Name
+					powerUsageLabels["direction"] = "in"
+					sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.ATotalActEnergy)
+
+					powerUsageLabels = copyLabelMap(targetLabels)
+					powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
+					powerUsageLabels["name"] = configData.Name
+					powerUsageLabels["direction"] = "out"
+					sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.ATotalActRetEnergy)
+
+					// phase B
+					phase = "B"
+					powerUsageLabels = copyLabelMap(targetLabels)
+					powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
+					powerUsageLabels["name"] = configData.Name
+					powerUsageLabels["direction"] = "in"
+					sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.BTotalActEnergy)
+
+					powerUsageLabels = copyLabelMap(targetLabels)
+					powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
+					powerUsageLabels["name"] = configData.Name
+					powerUsageLabels["direction"] = "out"
+					sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.BTotalActRetEnergy)
+
+					// phase C
+					phase = "C"
+					powerUsageLabels = copyLabelMap(targetLabels)
+					powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
+					powerUsageLabels["name"] = configData.Name
+					powerUsageLabels["direction"] = "in"
+					sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.CTotalActEnergy)
+
+					powerUsageLabels = copyLabelMap(targetLabels)
+					powerUsageLabels["id"] = fmt.Sprintf("em:%d:%s", configData.Id, phase)
+					powerUsageLabels["name"] = configData.Name
+					powerUsageLabels["direction"] = "out"
+					sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.CTotalActRetEnergy)
+
+					// device totals
+					powerUsageLabels = copyLabelMap(targetLabels)
+					powerUsageLabels["id"] = fmt.Sprintf("em:%d", configData.Id)
+					powerUsageLabels["name"] = configData.Name
+					powerUsageLabels["direction"] = "in"
+					sp.prometheus.emLoadTotal.With(powerUsageLabels).Set(result.TotalAct)
+
+					powerUsageLabels["direction"] = "out"
+					sp.prometheus.emLoadTotal.With(powerUsageLabels).Set(result.TotalActRet)
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode switchStatus`, slog.Any("error", err))
+				}
+			}
+
+		// em1 (monophase energy meter)
+		case strings.HasPrefix(configName, "em1:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				powerUsageLabels := copyLabelMap(targetLabels)
+				powerUsageLabels["id"] = fmt.Sprintf("em1:%d", configData.Id)
+				powerUsageLabels["name"] = configData.Name
+
+				if result, err := shellyProber.GetEm1Status(configData.Id); err == nil {
+					sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(result.ActPower)
+					sp.prometheus.powerLoadApparentCurrent.With(powerUsageLabels).Set(result.AprtPower)
+					sp.prometheus.powerFactor.With(powerUsageLabels).Set(result.Pf)
+					sp.prometheus.powerFrequency.With(powerUsageLabels).Set(result.Freq)
+					sp.prometheus.powerVoltage.With(powerUsageLabels).Set(result.Voltage)
+					sp.prometheus.powerAmpere.With(powerUsageLabels).Set(result.Current)
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode em1Status`, slog.Any("error", err))
+				}
+
+				if result, err := shellyProber.GetEm1DataStatus(configData.Id); err == nil {
+					powerUsageLabels["direction"] = "in"
+					sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.TotalActEnergy)
+
+					powerUsageLabels["direction"] = "out"
+					sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.TotalActRetEnergy)
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode em1DataStatus`, slog.Any("error", err))
+				}
+			}
+
+		// temperatureSensor
+		case strings.HasPrefix(configName, "temperature:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetTemperatureStatus(configData.Id); err == nil {
+					tempLabels := copyLabelMap(targetLabels)
+					tempLabels["id"] = fmt.Sprintf("sensor:%d", configData.Id)
+					tempLabels["name"] = configData.Name
+
+					sp.prometheus.temp.With(tempLabels).Set(result.TC)
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode temperatureStatus`, slog.Any("error", err))
+				}
+			}
+
+		// humiditySensor
+		case strings.HasPrefix(configName, "humidity:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetHumidityStatus(configData.Id); err == nil {
+					sensorLabels := copyLabelMap(targetLabels)
+					sensorLabels["id"] = fmt.Sprintf("humidity:%d", configData.Id)
+					sensorLabels["name"] = configData.Name
+
+					if result.Rh != nil {
+						sp.prometheus.humidity.With(sensorLabels).Set(*result.Rh)
+					}
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode humidityStatus`, slog.Any("error", err))
+				}
+			}
+
+		// illuminanceSensor
+		case strings.HasPrefix(configName, "illuminance:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetIlluminanceStatus(configData.Id); err == nil {
+					sensorLabels := copyLabelMap(targetLabels)
+					sensorLabels["id"] = fmt.Sprintf("illuminance:%d", configData.Id)
+					sensorLabels["name"] = configData.Name
+
+					if result.Lux != nil {
+						sp.prometheus.illuminance.With(sensorLabels).Set(*result.Lux)
+					}
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode illuminanceStatus`, slog.Any("error", err))
+				}
+			}
+
+		// voltmeter
+		case strings.HasPrefix(configName, "voltmeter:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetVoltmeterStatus(configData.Id); err == nil {
+					sensorLabels := copyLabelMap(targetLabels)
+					sensorLabels["id"] = fmt.Sprintf("voltmeter:%d", configData.Id)
+					sensorLabels["name"] = configData.Name
+
+					if result.Voltage != nil {
+						sp.prometheus.voltmeter.With(sensorLabels).Set(*result.Voltage)
+					}
+					if result.Xvoltage != nil {
+						sp.prometheus.voltmeterValue.With(sensorLabels).Set(*result.Xvoltage)
+					}
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode voltmeterStatus`, slog.Any("error", err))
+				}
+			}
+
+		// devicePower (battery)
+		case strings.HasPrefix(configName, "devicepower:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetDevicePowerStatus(configData.Id); err == nil {
+					sensorLabels := copyLabelMap(targetLabels)
+					sensorLabels["id"] = fmt.Sprintf("devicepower:%d", configData.Id)
+					sensorLabels["name"] = configData.Name
+
+					if result.Battery.Percent != nil {
+						sp.prometheus.batteryPercent.With(sensorLabels).Set(*result.Battery.Percent)
+					}
+					if result.Battery.V != nil {
+						sp.prometheus.batteryVoltage.With(sensorLabels).Set(*result.Battery.V)
+					}
+					sp.prometheus.externalPower.With(sensorLabels).Set(boolToFloat64(result.External.Present))
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode devicePowerStatus`, slog.Any("error", err))
+				}
+			}
+
+		// flood sensor (battery is reported by devicepower)
+		case strings.HasPrefix(configName, "flood:"):
+			if configData, err := decodeShellyConfigValueToItem(configValue); err == nil {
+				if result, err := shellyProber.GetFloodStatus(configData.Id); err == nil {
+					sensorLabels := copyLabelMap(targetLabels)
+					sensorLabels["id"] = fmt.Sprintf("flood:%d", configData.Id)
+					sensorLabels["name"] = configData.Name
+
+					sp.prometheus.flood.With(sensorLabels).Set(boolToFloat64(result.Alarm))
+					sp.prometheus.floodMute.With(sensorLabels).Set(boolToFloat64(result.Mute))
+				} else if !isStatusOnlyError(err) {
+					logger.Error(`failed to decode floodStatus`, slog.Any("error", err))
 				}
 			}
 		}
-	} else {
-		logger.Error(`failed to fetch status`, slog.Any("error", err))
-		if discovery.ServiceDiscovery != nil {
-			discovery.ServiceDiscovery.MarkTarget(target.Address, discovery.TargetUnhealthy)
+	}
+
+	return up
+}
+
+func (sp *ShellyPlug) collectGen2Light(id, name string, result shellyprober.ShellyProberGen2ResultLight, targetLabels prometheus.Labels) {
+	switchLabels := copyLabelMap(targetLabels)
+	switchLabels["id"] = id
+	switchLabels["name"] = name
+
+	switchOnLabels := copyLabelMap(switchLabels)
+	switchOnLabels["source"] = result.Source
+
+	sp.prometheus.switchOn.With(switchOnLabels).Set(boolToFloat64(result.Output))
+	sp.prometheus.switchOverpower.With(switchLabels).Set(boolToFloat64(slices.Contains(result.Errors, "overpower")))
+
+	lightLabels := copyLabelMap(switchLabels)
+	if result.Brightness != nil {
+		sp.prometheus.lightBrightness.With(lightLabels).Set(*result.Brightness)
+	}
+
+	if result.Ct != nil {
+		sp.prometheus.lightTemperature.With(lightLabels).Set(*result.Ct)
+	}
+
+	for num, channel := range []string{"red", "green", "blue"} {
+		if num < len(result.Rgb) {
+			lightColorLabels := copyLabelMap(lightLabels)
+			lightColorLabels["channel"] = channel
+			sp.prometheus.lightColor.With(lightColorLabels).Set(result.Rgb[num])
 		}
 	}
+
+	if result.White != nil {
+		lightColorLabels := copyLabelMap(lightLabels)
+		lightColorLabels["channel"] = "white"
+		sp.prometheus.lightColor.With(lightColorLabels).Set(*result.White)
+	}
+
+	if result.Temperature.TC != nil {
+		sp.prometheus.temp.With(lightLabels).Set(*result.Temperature.TC)
+		sp.prometheus.overTemp.With(lightLabels).Set(boolToFloat64(slices.Contains(result.Errors, "overtemp")))
+	}
+
+	// power metrics are only available on devices with power metering
+	powerUsageLabels := copyLabelMap(lightLabels)
+	if result.Apower != nil {
+		sp.prometheus.powerLoadCurrent.With(powerUsageLabels).Set(*result.Apower)
+	}
+	if result.Voltage != nil {
+		sp.prometheus.powerVoltage.With(powerUsageLabels).Set(*result.Voltage)
+	}
+	if result.Current != nil {
+		sp.prometheus.powerAmpere.With(powerUsageLabels).Set(*result.Current)
+	}
+	if result.Aenergy != nil {
+		// total is provided as watt/hours
+		powerUsageLabels["direction"] = "in"
+		sp.prometheus.powerLoadTotal.With(powerUsageLabels).Set(result.Aenergy.Total)
+	}
 }
 
 func decodeShellyConfigValueToItem(val interface{}) (shellyGen2ConfigValue, error) {
@@ -11,19 +11,29 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/webdevops/go-common/log/slogger"
 
+	"github.com/webdevops/shelly-plug-exporter/credentials"
 	"github.com/webdevops/shelly-plug-exporter/discovery"
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+const (
+	// cancelGracePeriod is the time the collectors have to finish after the scrape deadline is reached
+	cancelGracePeriod = 100 * time.Millisecond
 )
 
 type (
+	// StateMirror provides the mirrored status of gen2+ targets (eg. websocket connections), keyed by DiscoveryTarget.Key
+	StateMirror interface {
+		GetDevice(key string) (shellystate.Device, bool)
+	}
+
 	ShellyPlug struct {
 		ctx      context.Context
 		logger   *slogger.Logger
 		registry *prometheus.Registry
 
-		auth struct {
-			username string
-			password string
-		}
+		credentials *credentials.Store
+		adHocAuth   bool
 
 		resty struct {
 			timeout          time.Duration
@@ -39,6 +49,13 @@ type (
 			lock sync.RWMutex
 		}
 
+		stateStores struct {
+			list []*shellystate.Store
+			lock sync.RWMutex
+		}
+
+		stateMirror StateMirror
+
 		prometheus shellyPlugMetrics
 	}
 )
@@ -50,13 +67,12 @@ func New(ctx context.Context, registry *prometheus.Registry, logger *slogger.Log
 	sp.logger = logger
 	sp.initMetrics()
 
-	if globalCache == nil {
+	// probes are running concurrently (eg. multiple Prometheus instances)
+	cacheInitOnce.Do(func() {
 		globalCache = cache.New(15*time.Minute, 1*time.Minute)
-	}
-
-	if restyCache == nil {
-		restyCache = cache.New(1*time.Hour, 1*time.Minute)
-	}
+		restyCache = cache.New(restyCacheExpiration, 1*time.Minute)
+		deviceSemaphores = cache.New(restyCacheExpiration+deviceSemaphoreGrace, 1*time.Minute)
+	})
 
 	return &sp
 }
@@ -74,6 +90,8 @@ func (sp *ShellyPlug) GetTargets() []discovery.DiscoveryTarget {
 	return sp.targets.list
 }
 
+// Run collects the metrics of all targets and devices, if the probe context is done before all targets
+// are finished the partial results are returned (pending requests are cancelled by the context)
 func (sp *ShellyPlug) Run() {
 	wg := sync.WaitGroup{}
 
@@ -82,10 +100,45 @@ func (sp *ShellyPlug) Run() {
 		wg.Add(1)
 		go func(target discovery.DiscoveryTarget) {
 			defer wg.Done()
+
+			if err := acquireSemaphore(sp.ctx, targetSemaphore); err != nil {
+				sp.logger.Warn("skipping target, concurrency limit reached until scrape deadline", slog.String("target", target.Name()))
+				sp.prometheus.up.With(prometheus.Labels{"target": TargetLabel(target)}).Set(0)
+				sp.prometheus.scrapeSkipped.With(prometheus.Labels{"target": TargetLabel(target)}).Set(1)
+				return
+			}
+			defer releaseSemaphore(targetSemaphore)
+
 			sp.collectFromTarget(target)
 		}(target)
 	}
-	wg.Wait()
+
+	for _, row := range sp.GetStateDevices() {
+		device := row
+		wg.Add(1)
+		go func(device shellystate.Device) {
+			defer wg.Done()
+			sp.collectFromDevice(device)
+		}(device)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-sp.ctx.Done():
+		sp.logger.Warn("scrape deadline reached, returning partial results", slog.Any("error", sp.ctx.Err()))
+
+		// pending requests are cancelled now, give the collectors a moment to report the failed targets
+		select {
+		case <-done:
+		case <-time.After(cancelGracePeriod):
+		}
+	}
 }
 
 func (sp *ShellyPlug) collectFromTarget(target discovery.DiscoveryTarget) {
@@ -97,16 +150,35 @@ func (sp *ShellyPlug) collectFromTarget(target discovery.DiscoveryTarget) {
 		),
 	)
 
+	// the circuit breaker is only moved together with breakerKey, so allow and record are using the same breaker
+	breakerKey := target.Key()
+	if allowed, state := circuitBreakers.allow(breakerKey); !allowed {
+		sp.prometheus.circuitBreaker.With(prometheus.Labels{"target": TargetLabel(target)}).Set(circuitBreakerStateValue(state))
+		if state == CircuitBreakerHalfOpen {
+			// another probe (eg. HA Prometheus pair) is testing the device, the device is not marked as down
+			targetLogger.Debug("skipping shelly device, circuit breaker is half open and probe is already running")
+			return
+		}
+		targetLogger.Debug("skipping shelly device, circuit breaker is open")
+		sp.prometheus.up.With(prometheus.Labels{"target": TargetLabel(target)}).Set(0)
+		return
+	}
+
+	up := false
+	defer func() {
+		sp.recordCircuitBreaker(target, breakerKey, targetLogger, up)
+	}()
+
 	targetLogger.Debug("probing shelly device")
 
 	targetLabels := prometheus.Labels{
-		"target":   target.Address,
+		"target":   TargetLabel(target),
 		"mac":      "",
 		"plugName": "",
 	}
 
 	infoLabels := prometheus.Labels{
-		"target":         target.Address,
+		"target":         TargetLabel(target),
 		"mac":            "",
 		"hostname":       "",
 		"plugName":       "",
@@ -114,6 +186,7 @@ func (sp *ShellyPlug) collectFromTarget(target discovery.DiscoveryTarget) {
 		"plugApp":        "",
 		"plugGeneration": "",
 	}
+	addCustomLabels(infoLabels, target.Labels)
 
 	shellyGeneration := 0
 	if result, err := sp.targetGetShellyInfo(target); err == nil {
@@ -124,6 +197,34 @@ func (sp *ShellyPlug) collectFromTarget(target discovery.DiscoveryTarget) {
 			shellyGeneration = 1
 		}
 
+		// generation override (config file)
+		if target.Static && target.Generation != "" {
+			if val, err := strconv.Atoi(target.Generation); err == nil {
+				shellyGeneration = val
+			}
+		}
+
+		if deviceId := discovery.NormalizeDeviceId(result.Mac); deviceId != "" {
+			if target.DeviceId != "" && target.DeviceId != deviceId && !target.Static {
+				// address is used by another device now (eg. DHCP), the target is updated by the service discovery
+				targetLogger.Warn(`unexpected shelly device at target address`, slog.String("deviceId", deviceId), slog.String("expectedDeviceId", target.DeviceId))
+				if discovery.ServiceDiscovery != nil {
+					discovery.ServiceDiscovery.SetTargetDeviceId(target.Key(), deviceId)
+				}
+				sp.prometheus.up.With(prometheus.Labels{"target": TargetLabel(target)}).Set(0)
+				return
+			}
+
+			if discovery.ServiceDiscovery != nil {
+				discovery.ServiceDiscovery.SetTargetDeviceId(target.Key(), deviceId)
+			}
+			target.DeviceId = deviceId
+			circuitBreakers.move(breakerKey, target.Key())
+			breakerKey = target.Key()
+			targetLabels["target"] = TargetLabel(target)
+			infoLabels["target"] = TargetLabel(target)
+		}
+
 		targetLabels["plugName"] = result.Name
 		targetLabels["mac"] = result.Mac
 
@@ -136,18 +237,21 @@ func (sp *ShellyPlug) collectFromTarget(target discovery.DiscoveryTarget) {
 
 	} else {
 		targetLogger.Error(`failed to fetch settings`, slog.Any("error", err))
-		if discovery.ServiceDiscovery != nil {
-			discovery.ServiceDiscovery.MarkTarget(target.Address, discovery.TargetUnhealthy)
-		}
+		sp.markTargetUnhealthy(target)
+		sp.prometheus.up.With(prometheus.Labels{"target": TargetLabel(target)}).Set(0)
+		return
 	}
 
 	targetLogger = targetLogger.With(slog.Int("gen", shellyGeneration))
 	switch shellyGeneration {
 	case 1:
-		sp.collectFromTargetGen1(target, targetLogger, infoLabels, targetLabels)
-	case 2:
-		sp.collectFromTargetGen2(target, targetLogger, infoLabels, targetLabels)
+		up = sp.collectFromTargetGen1(target, targetLogger, infoLabels, targetLabels)
+	case 2, 3, 4:
+		// gen3 and gen4 devices are using the same RPC api as gen2
+		up = sp.collectFromTargetGen2(target, targetLogger, infoLabels, targetLabels)
 	default:
 		targetLogger.Warn("unsupported Shelly generation", slog.Int("gen", shellyGeneration))
 	}
+
+	sp.prometheus.up.With(prometheus.Labels{"target": TargetLabel(target)}).Set(boolToFloat64(up))
 }
@@ -1,23 +1,39 @@
 package shellyplug
 
 import (
-	"context"
-	"errors"
+	"crypto/sha256"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	resty "github.com/go-resty/resty/v2"
 	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/webdevops/go-common/log/slogger"
 
+	"github.com/webdevops/shelly-plug-exporter/credentials"
 	"github.com/webdevops/shelly-plug-exporter/discovery"
+	"github.com/webdevops/shelly-plug-exporter/shellyprober"
+)
+
+const (
+	restyAuthBasic  = "basic"
+	restyAuthDigest = "digest"
 )
 
 var (
 	restyCache *cache.Cache
 )
 
+type (
+	restyAuth struct {
+		scheme   string
+		username string
+		password string
+	}
+)
+
 func (sp *ShellyPlug) SetUserAgent(val string) {
 	sp.resty.userAgent = val
 }
@@ -32,15 +48,53 @@ func (sp *ShellyPlug) EnableRetry(retries int, waitTime, waitTimeMax time.Durati
 	sp.resty.retryWaitTimeMax = waitTimeMax
 }
 
-func (sp *ShellyPlug) SetHttpAuth(username, password string) {
-	sp.auth.username = username
-	sp.auth.password = password
+// SetCredentials sets the credential store, devices are matched by mac, hostname or address.
+// Ad-hoc targets (/probe?target=) only get credentials with adHoc enabled or by CIDR range of the address
+func (sp *ShellyPlug) SetCredentials(store *credentials.Store, adHoc bool) {
+	sp.credentials = store
+	sp.adHocAuth = adHoc
+}
+
+// targetAuth returns the credentials of the target (config file) or the matching credentials of the credential store
+func (sp *ShellyPlug) targetAuth(target discovery.DiscoveryTarget, mac string, logger *slogger.Logger) (username, password string) {
+	credential := target.Auth
+	switch {
+	case credential != nil:
+	case target.AdHoc && !sp.adHocAuth:
+		// the probed host can be chosen by anyone who can reach /probe, so credentials are not sent to arbitrary hosts
+		credential = sp.credentials.LookupNetwork(target.Address)
+	default:
+		credential = sp.credentials.Lookup(target.Hostname, target.Address, mac)
+	}
+
+	if credential == nil {
+		return "", ""
+	}
+
+	logger.Debug("using credentials", slog.String("credentials", credential.Name))
+
+	password, err := credential.GetPassword()
+	if err != nil {
+		logger.Error("failed to read password", slog.String("credentials", credential.Name), slog.Any("error", err))
+	}
+
+	return credential.Username, password
+}
+
+func (sp *ShellyPlug) restyClient(target discovery.DiscoveryTarget, logger *slogger.Logger) *resty.Client {
+	return sp.restyClientWithAuth(target, restyAuth{}, logger)
 }
 
-func (sp *ShellyPlug) restyClient(ctx context.Context, target discovery.DiscoveryTarget, logger *slogger.Logger) (client *resty.Client) {
-	cacheKey := target.Address
+// restyClientWithAuth returns the (cached) client of the target, the authentication is part of the cache key
+// as cached clients are shared by concurrent probes and must not be modified
+func (sp *ShellyPlug) restyClientWithAuth(target discovery.DiscoveryTarget, auth restyAuth, logger *slogger.Logger) (client *resty.Client) {
+	// clients are cached per device, the address might change (eg. DHCP)
+	cacheKey := target.Key()
+	if auth.scheme != "" {
+		cacheKey = fmt.Sprintf("%s|%s|%s|%x", cacheKey, auth.scheme, auth.username, sha256.Sum256([]byte(auth.password)))
+	}
 	if val, ok := restyCache.Get(cacheKey); ok {
-		if client, ok := val.(*resty.Client); ok {
+		if client, ok := val.(*resty.Client); ok && client.BaseURL == target.BaseUrl() {
 			return client
 		}
 	}
@@ -54,9 +108,16 @@ func (sp *ShellyPlug) restyClient(ctx context.Context, target discovery.Discover
 	client.SetBaseURL(target.BaseUrl())
 	client.SetLogger(restyLogger)
 	client.SetTimeout(5 * time.Second)
-	if sp.resty.timeout.Seconds() > 0 {
+	if target.Timeout.Seconds() > 0 {
+		client.SetTimeout(target.Timeout)
+	} else if sp.resty.timeout.Seconds() > 0 {
 		client.SetTimeout(sp.resty.timeout)
 	}
+	// every attempt is limited by the client timeout and the remaining probe deadline
+	client.SetTransport(&shellyprober.AttemptTransport{Transport: client.GetClient().Transport, Timeout: client.GetClient().Timeout})
+	if semaphore := deviceSemaphore(target.Key()); semaphore != nil {
+		client.SetTransport(&deviceTransport{transport: client.GetClient().Transport, semaphore: semaphore})
+	}
 
 	if sp.resty.userAgent != "" {
 		client.SetHeader("User-Agent", sp.resty.userAgent)
@@ -68,17 +129,12 @@ func (sp *ShellyPlug) restyClient(ctx context.Context, target discovery.Discover
 		if sp.resty.retryWaitTime.Seconds() > 0 {
 			client.SetRetryWaitTime(sp.resty.retryWaitTime)
 		}
+		// retries are using a jittered exponential backoff between wait time and max wait time
 		if sp.resty.retryWaitTimeMax.Seconds() > 0 {
-			client.SetRetryWaitTime(sp.resty.retryWaitTimeMax)
+			client.SetRetryMaxWaitTime(sp.resty.retryWaitTimeMax)
 		}
 	}
 
-	if sp.auth.username != "" {
-		client.SetDisableWarn(true)
-		client.SetBasicAuth(sp.auth.username, sp.auth.password)
-		client.SetDigestAuth(sp.auth.username, sp.auth.password)
-	}
-
 	// client.AddRequestMiddleware(func(c *resty.Client, req *resty.Request) error {
 	// 	c.Logger().(*slogger.Logger).With(
 	// 		slog.String("method", req.Method),
@@ -108,16 +164,47 @@ func (sp *ShellyPlug) restyClient(ctx context.Context, target discovery.Discover
 	client.OnAfterResponse(func(c *resty.Client, res *resty.Response) error {
 		switch res.StatusCode() {
 		case 401:
-			return errors.New(`shelly plug requires authentication and/or credentials are invalid`)
+			return ErrAuthenticationRequired
 		case 200:
 			// all ok, proceed
 			return nil
 		default:
-			return fmt.Errorf(`expected http status 200, got %v`, res.StatusCode())
+			return &HttpStatusError{StatusCode: res.StatusCode()}
 		}
 	})
 
+	// auth is only set here as the client is cached and shared between concurrent scrapes,
+	// digest auth is wrapping the transport, so it has to be set after the transport
+	switch auth.scheme {
+	case restyAuthBasic:
+		client.SetDisableWarn(true)
+		client.SetBasicAuth(auth.username, auth.password)
+	case restyAuthDigest:
+		client.SetTransport(&digestTransport{transport: client.GetClient().Transport, username: auth.username, password: auth.password})
+	}
+
 	restyCache.SetDefault(cacheKey, client)
 
 	return
 }
+
+// requestObserver returns a callback which records duration and errors of device requests as metrics
+func (sp *ShellyPlug) requestObserver(target discovery.DiscoveryTarget) func(endpoint string, duration time.Duration, err error) {
+	return func(endpoint string, duration time.Duration, err error) {
+		// query parameters (eg. component id) are not part of the endpoint label
+		endpoint, _, _ = strings.Cut(endpoint, "?")
+
+		sp.prometheus.scrapeDuration.With(prometheus.Labels{
+			"target":   TargetLabel(target),
+			"endpoint": endpoint,
+		}).Set(duration.Seconds())
+
+		if err != nil {
+			sp.prometheus.scrapeErrors.With(prometheus.Labels{
+				"target":   TargetLabel(target),
+				"endpoint": endpoint,
+				"class":    scrapeErrorClass(err),
+			}).Add(1)
+		}
+	}
+}
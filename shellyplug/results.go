@@ -2,6 +2,7 @@ package shellyplug
 
 import (
 	"github.com/webdevops/shelly-plug-exporter/discovery"
+	"github.com/webdevops/shelly-plug-exporter/shellyprober"
 )
 
 type (
@@ -22,9 +23,22 @@ type (
 func (sp *ShellyPlug) targetGetShellyInfo(target discovery.DiscoveryTarget) (ResultShellyInfo, error) {
 	result := ResultShellyInfo{}
 
-	client := sp.restyClient(sp.ctx, target, sp.logger)
+	// device info is not fetched on every scrape if the status is mirrored (eg. websocket connection),
+	// the device configuration (Shelly.GetConfig) is cached anyway
+	cacheKey := target.BaseUrl() + "/shelly"
+	if _, mirrored := sp.getMirroredDevice(target); mirrored {
+		if val, ok := globalCache.Get(cacheKey); ok {
+			if cachedResult, ok := val.(ResultShellyInfo); ok {
+				return cachedResult, nil
+			}
+		}
+	}
+
+	client := sp.restyClient(target, sp.logger)
 
-	r := client.R().ForceContentType("application/json").SetResult(&result)
-	_, err := r.Get("/shelly")
+	err := shellyprober.Fetch(sp.ctx, client, "/shelly", &result, sp.requestObserver(target))
+	if err == nil {
+		globalCache.SetDefault(cacheKey, result)
+	}
 	return result, err
 }
@@ -0,0 +1,202 @@
+package shellyplug
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/webdevops/shelly-plug-exporter/shellyprober"
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+// UseStateStore adds the devices of the state store (eg. pushed devices) to the probe
+func (sp *ShellyPlug) UseStateStore(store *shellystate.Store) {
+	sp.stateStores.lock.Lock()
+	defer sp.stateStores.lock.Unlock()
+	sp.stateStores.list = append(sp.stateStores.list, store)
+}
+
+// UseStateMirror uses the mirrored status for gen2+ targets instead of fetching the status from the device
+func (sp *ShellyPlug) UseStateMirror(mirror StateMirror) {
+	sp.stateMirror = mirror
+}
+
+func (sp *ShellyPlug) GetStateDevices() []shellystate.Device {
+	sp.stateStores.lock.RLock()
+	defer sp.stateStores.lock.RUnlock()
+
+	ret := []shellystate.Device{}
+	for _, store := range sp.stateStores.list {
+		ret = append(ret, store.GetDevices()...)
+	}
+	return ret
+}
+
+func (sp *ShellyPlug) collectFromDevice(device shellystate.Device) {
+	deviceLogger := sp.logger.With(
+		slog.Group(
+			"device",
+			slog.String("id", device.ID),
+			slog.String("source", device.Source),
+		),
+	)
+
+	deviceLogger.Debug("collecting shelly device state")
+
+	targetLabels := prometheus.Labels{
+		"target":   device.ID,
+		"mac":      device.Mac(),
+		"plugName": "",
+	}
+
+	infoLabels := prometheus.Labels{
+		"target":         device.ID,
+		"mac":            device.Mac(),
+		"hostname":       device.ID,
+		"plugName":       "",
+		"plugModel":      device.Model,
+		"plugApp":        "",
+		"plugGeneration": strconv.Itoa(device.Generation),
+	}
+	addCustomLabels(infoLabels, nil)
+	sp.prometheus.info.With(infoLabels).Set(1)
+
+	lastSeenLabels := copyLabelMap(targetLabels)
+	lastSeenLabels["source"] = device.Source
+	sp.prometheus.lastSeen.With(lastSeenLabels).Set(float64(device.LastSeen.Unix()))
+
+	if len(device.Values) > 0 {
+		sp.collectSensorValues(device.Values, targetLabels)
+	}
+
+	if len(device.Components) > 0 {
+		// status only prober, all components are decoded from the device state
+		shellyProber := shellyprober.ShellyProberGen2{
+			Status: device.Components,
+		}
+		shellyConfig := shellyConfigFromStatus(device.Components)
+		sp.collectGen2Status(&shellyProber, shellyConfig, deviceLogger, targetLabels)
+		sp.collectOutputChanges(device, shellyConfig, targetLabels)
+	}
+}
+
+// collectOutputChanges collects the number of output changes seen in status notifications
+func (sp *ShellyPlug) collectOutputChanges(device shellystate.Device, shellyConfig shellyprober.ShellyProberGen2ResultShellyConfig, targetLabels prometheus.Labels) {
+	for key, changes := range device.OutputChanges {
+		switchLabels := copyLabelMap(targetLabels)
+		switchLabels["id"] = key
+		switchLabels["name"] = ""
+		if configData, err := decodeShellyConfigValueToItem(shellyConfig[key]); err == nil {
+			switchLabels["name"] = configData.Name
+		}
+		sp.prometheus.switchOutputChange.With(switchLabels).Add(float64(changes))
+	}
+}
+
+// collectSensorValues collects the metrics from sensor values (eg. pushed values or CoIoT)
+func (sp *ShellyPlug) collectSensorValues(values map[string]shellystate.Value, targetLabels prometheus.Labels) {
+	for _, value := range values {
+		labels := copyLabelMap(targetLabels)
+		labels["id"], labels["name"] = gen1BlockToId(value)
+
+		switch value.Type {
+		case shellystate.SensorTypeTemperature:
+			sp.prometheus.temp.With(labels).Set(value.Value)
+		case shellystate.SensorTypeHumidity:
+			sp.prometheus.humidity.With(labels).Set(value.Value)
+		case shellystate.SensorTypeLuminosity:
+			sp.prometheus.illuminance.With(labels).Set(value.Value)
+		case shellystate.SensorTypeBattery:
+			sp.prometheus.batteryPercent.With(labels).Set(value.Value)
+		case shellystate.SensorTypePower:
+			sp.prometheus.powerLoadCurrent.With(labels).Set(value.Value)
+		case shellystate.SensorTypeVoltage:
+			sp.prometheus.powerVoltage.With(labels).Set(value.Value)
+		case shellystate.SensorTypeCurrent:
+			sp.prometheus.powerAmpere.With(labels).Set(value.Value)
+		case shellystate.SensorTypeEnergy:
+			labels["direction"] = "in"
+			if strings.Contains(strings.ToLower(value.Name), "returned") {
+				labels["direction"] = "out"
+			}
+
+			switch strings.ToLower(value.Unit) {
+			case "wmin":
+				// total is provided as watt/minutes, we want watt/hours
+				sp.prometheus.powerLoadTotal.With(labels).Set(value.Value / 60)
+			default:
+				sp.prometheus.powerLoadTotal.With(labels).Set(value.Value)
+			}
+		case shellystate.SensorTypeStatus:
+			switch value.Name {
+			case "output":
+				labels["source"] = ""
+				sp.prometheus.switchOn.With(labels).Set(value.Value)
+			case "brightness":
+				sp.prometheus.lightBrightness.With(labels).Set(value.Value)
+			case "rollerPos":
+				sp.prometheus.coverPosition.With(labels).Set(value.Value)
+			case "input":
+				sp.prometheus.inputState.With(labels).Set(value.Value)
+			case "dwIsOpened":
+				sp.prometheus.doorOpen.With(labels).Set(value.Value)
+			}
+		case shellystate.SensorTypeAlarm:
+			switch value.Name {
+			case "overpower":
+				sp.prometheus.switchOverpower.With(labels).Set(value.Value)
+			case "overtemp":
+				sp.prometheus.overTemp.With(labels).Set(value.Value)
+			case "flood":
+				sp.prometheus.flood.With(labels).Set(value.Value)
+			}
+		case shellystate.SensorTypeEventCount:
+			if value.Value >= 0 {
+				sp.prometheus.inputCounter.With(labels).Add(value.Value)
+			}
+		}
+	}
+}
+
+// gen1BlockToId converts gen1 blocks (eg. relay_0) to the ids used by the http collector (eg. relay:0)
+func gen1BlockToId(value shellystate.Value) (id string, name string) {
+	if value.Block == "device" {
+		return "sensor:0", "system"
+	}
+
+	blockType, blockId, found := strings.Cut(value.Block, "_")
+	if !found {
+		return value.Block, ""
+	}
+
+	// power metering of relays is exported as meter (same as /status meters)
+	if blockType == "relay" && (value.Type == shellystate.SensorTypePower || value.Type == shellystate.SensorTypeEnergy) {
+		blockType = "meter"
+	}
+
+	return fmt.Sprintf("%s:%s", blockType, blockId), ""
+}
+
+// shellyConfigFromStatus builds the component list from the status (devices without configuration)
+func shellyConfigFromStatus(status map[string]interface{}) shellyprober.ShellyProberGen2ResultShellyConfig {
+	ret := shellyprober.ShellyProberGen2ResultShellyConfig{}
+	for name := range status {
+		if _, rawId, found := strings.Cut(name, ":"); found {
+			if id, err := strconv.Atoi(rawId); err == nil {
+				ret[name] = map[string]interface{}{
+					"id":   id,
+					"name": "",
+				}
+			}
+		}
+	}
+	return ret
+}
+
+func isStatusOnlyError(err error) bool {
+	return errors.Is(err, shellyprober.ErrNoClient)
+}
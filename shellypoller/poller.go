@@ -0,0 +1,233 @@
+package shellypoller
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevops/shelly-plug-exporter/discovery"
+	"github.com/webdevops/shelly-plug-exporter/shellyplug"
+)
+
+const (
+	// interval for syncing the polled targets with the discovered targets
+	syncInterval = 30 * time.Second
+
+	upMetricName = "shellyplug_up"
+)
+
+type (
+	// ProberFactory creates a configured prober (user agent, timeouts, credentials, ...) for one poll
+	ProberFactory func(ctx context.Context, registry *prometheus.Registry, logger *slogger.Logger) *shellyplug.ShellyPlug
+
+	// Poller polls every discovery target in background (each target with its own interval)
+	// and keeps the last good snapshot of the target metrics
+	Poller struct {
+		logger   *slogger.Logger
+		factory  ProberFactory
+		interval time.Duration
+		maxAge   time.Duration
+
+		lock    sync.RWMutex
+		targets map[string]*polledTarget
+	}
+
+	polledTarget struct {
+		target discovery.DiscoveryTarget
+		cancel context.CancelFunc
+
+		lock        sync.RWMutex
+		snapshot    []*dto.MetricFamily
+		lastSuccess time.Time
+	}
+)
+
+func NewPoller(logger *slogger.Logger, factory ProberFactory, interval, maxAge time.Duration) *Poller {
+	return &Poller{
+		logger:   logger,
+		factory:  factory,
+		interval: interval,
+		maxAge:   maxAge,
+		targets:  map[string]*polledTarget{},
+	}
+}
+
+// Run syncs the polled targets with the service discovery targets
+func (p *Poller) Run(ctx context.Context) {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		if discovery.ServiceDiscovery != nil {
+			p.Sync(ctx, discovery.ServiceDiscovery.GetTargetList())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync starts polling of new targets and stops polling of removed (or changed) targets
+func (p *Poller) Sync(ctx context.Context, targets []discovery.DiscoveryTarget) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// targets are keyed by device id (if known), address changes are restarting the polling
+	current := map[string]discovery.DiscoveryTarget{}
+	for _, target := range targets {
+		current[target.Key()] = target
+	}
+
+	for key, entry := range p.targets {
+		if target, ok := current[key]; !ok || target.Address != entry.target.Address || target.Port != entry.target.Port || target.PollInterval != entry.target.PollInterval {
+			p.logger.Info("stopping polling", slog.String("target", entry.target.Name()))
+			entry.cancel()
+			delete(p.targets, key)
+		}
+	}
+
+	for key, target := range current {
+		if _, ok := p.targets[key]; ok {
+			continue
+		}
+
+		interval := p.interval
+		if target.PollInterval > 0 {
+			interval = target.PollInterval
+		}
+
+		p.logger.Info("starting polling", slog.String("target", target.Name()), slog.Duration("interval", interval))
+		pollCtx, cancel := context.WithCancel(ctx)
+		entry := &polledTarget{target: target, cancel: cancel}
+		p.targets[key] = entry
+		go p.runTarget(pollCtx, entry, interval)
+	}
+}
+
+func (p *Poller) runTarget(ctx context.Context, entry *polledTarget, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.poll(ctx, entry, interval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll probes the target, failed polls are keeping the last good snapshot until it's older than maxAge
+func (p *Poller) poll(ctx context.Context, entry *polledTarget, timeout time.Duration) {
+	logger := p.logger.With(slog.String("target", entry.target.Name()))
+
+	pollCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	lastSuccessMetric := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "shellyplug_last_success_timestamp_seconds",
+			Help: "Timestamp of the last successful poll of the device (background polling)",
+		},
+		[]string{"target"},
+	)
+	registry.MustRegister(lastSuccessMetric)
+
+	sp := p.factory(pollCtx, registry, p.logger)
+	sp.SetTargets([]discovery.DiscoveryTarget{entry.target})
+	sp.Run()
+
+	if ctx.Err() != nil {
+		// polling was stopped
+		return
+	}
+
+	metrics, err := registry.Gather()
+	if err != nil {
+		logger.Error("failed to gather metrics", slog.Any("error", err))
+		return
+	}
+
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	success := isTargetUp(metrics)
+	if success {
+		entry.lastSuccess = time.Now()
+	} else if entry.snapshot != nil && time.Since(entry.lastSuccess) <= p.maxAge {
+		logger.Debug("poll failed, keeping last good snapshot", slog.Time("lastSuccess", entry.lastSuccess))
+		return
+	}
+
+	if !entry.lastSuccess.IsZero() {
+		lastSuccessMetric.WithLabelValues(shellyplug.TargetLabel(entry.target)).Set(float64(entry.lastSuccess.Unix()))
+		if metrics, err = registry.Gather(); err != nil {
+			logger.Error("failed to gather metrics", slog.Any("error", err))
+			return
+		}
+	}
+
+	entry.snapshot = metrics
+}
+
+// Gather returns the snapshots of all polled targets (implements prometheus.Gatherer)
+func (p *Poller) Gather() ([]*dto.MetricFamily, error) {
+	p.lock.RLock()
+	gatherers := prometheus.Gatherers{}
+	for _, entry := range p.targets {
+		gatherers = append(gatherers, entry)
+	}
+	p.lock.RUnlock()
+
+	return gatherers.Gather()
+}
+
+// GathererFor returns the snapshot of the target (see DiscoveryTarget.Key), false if the target is not polled (or has no snapshot yet)
+func (p *Poller) GathererFor(key string) (prometheus.Gatherer, bool) {
+	p.lock.RLock()
+	entry, ok := p.targets[key]
+	p.lock.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	entry.lock.RLock()
+	defer entry.lock.RUnlock()
+	return entry, entry.snapshot != nil
+}
+
+// Gather returns the snapshot of the target (implements prometheus.Gatherer)
+func (t *polledTarget) Gather() ([]*dto.MetricFamily, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.snapshot, nil
+}
+
+// isTargetUp returns true if the target was scraped successfully (shellyplug_up)
+func isTargetUp(metrics []*dto.MetricFamily) bool {
+	for _, family := range metrics {
+		if family.GetName() != upMetricName {
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			if metric.GetGauge().GetValue() != 1 {
+				return false
+			}
+		}
+		return len(family.GetMetric()) > 0
+	}
+	return false
+}
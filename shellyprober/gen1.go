@@ -2,6 +2,7 @@ package shellyprober
 
 import (
 	"context"
+	"time"
 
 	resty "github.com/go-resty/resty/v2"
 	"github.com/patrickmn/go-cache"
@@ -15,6 +16,9 @@ type (
 		Client *resty.Client
 		Ctx    context.Context
 		Cache  *cache.Cache
+
+		// RequestObserver is called after each device request (optional)
+		RequestObserver func(endpoint string, duration time.Duration, err error)
 	}
 
 	ShellyProberGen1ResultSettings struct {
@@ -61,6 +65,38 @@ type (
 			Overpower      bool   `json:"overpower"`
 			Source         string `json:"source"`
 		} `json:"relays"`
+		Inputs []struct {
+			Input    int    `json:"input"`
+			Event    string `json:"event"`
+			EventCnt int    `json:"event_cnt"`
+		} `json:"inputs"`
+		Lights []struct {
+			Ison       bool   `json:"ison"`
+			Source     string `json:"source"`
+			HasTimer   bool   `json:"has_timer"`
+			Mode       string `json:"mode"`
+			Red        *int   `json:"red"`
+			Green      *int   `json:"green"`
+			Blue       *int   `json:"blue"`
+			White      *int   `json:"white"`
+			Gain       *int   `json:"gain"`
+			Temp       *int   `json:"temp"`
+			Brightness *int   `json:"brightness"`
+			Overpower  bool   `json:"overpower"`
+		} `json:"lights"`
+		Rollers []struct {
+			State           string  `json:"state"`
+			Source          string  `json:"source"`
+			Power           float64 `json:"power"`
+			IsValid         bool    `json:"is_valid"`
+			SafetySwitch    bool    `json:"safety_switch"`
+			Overtemperature bool    `json:"overtemperature"`
+			StopReason      string  `json:"stop_reason"`
+			LastDirection   string  `json:"last_direction"`
+			CurrentPos      int     `json:"current_pos"`
+			Calibrating     bool    `json:"calibrating"`
+			Positioning     bool    `json:"positioning"`
+		} `json:"rollers"`
 		Meters []struct {
 			Power     float64   `json:"power"`
 			Overpower float64   `json:"overpower"`
@@ -69,13 +105,51 @@ type (
 			Counters  []float64 `json:"counters"`
 			Total     float64   `json:"total"`
 		} `json:"meters"`
-		Temperature     float64 `json:"temperature"`
-		Overtemperature bool    `json:"overtemperature"`
+		// Pf and Current of the emeters are only reported by the 3EM (not by the EM)
+		Emeters []struct {
+			Power         float64  `json:"power"`
+			Reactive      float64  `json:"reactive"`
+			Pf            *float64 `json:"pf"`
+			Voltage       float64  `json:"voltage"`
+			Current       *float64 `json:"current"`
+			IsValid       bool     `json:"is_valid"`
+			Total         float64  `json:"total"`
+			TotalReturned float64  `json:"total_returned"`
+		} `json:"emeters"`
+		Temperature     *float64 `json:"temperature"`
+		Overtemperature bool     `json:"overtemperature"`
+		Voltage         *float64 `json:"voltage"`
 		Tmp             struct {
 			TC      float64 `json:"tC"`
 			TF      float64 `json:"tF"`
 			IsValid bool    `json:"is_valid"`
 		} `json:"tmp"`
+		Hum struct {
+			Value   float64 `json:"value"`
+			IsValid bool    `json:"is_valid"`
+		} `json:"hum"`
+		Lux struct {
+			Value        float64 `json:"value"`
+			Illumination string  `json:"illumination"`
+			IsValid      bool    `json:"is_valid"`
+		} `json:"lux"`
+		Bat *struct {
+			Value   float64 `json:"value"`
+			Voltage float64 `json:"voltage"`
+		} `json:"bat"`
+		Charger        *bool `json:"charger"`
+		ExtTemperature map[string]struct {
+			HwID string  `json:"hwID"`
+			TC   float64 `json:"tC"`
+			TF   float64 `json:"tF"`
+		} `json:"ext_temperature"`
+		ExtHumidity map[string]struct {
+			HwID string  `json:"hwID"`
+			Hum  float64 `json:"hum"`
+		} `json:"ext_humidity"`
+		Adcs []struct {
+			Voltage float64 `json:"voltage"`
+		} `json:"adcs"`
 		Update struct {
 			Status     string `json:"status"`
 			HasUpdate  bool   `json:"has_update"`
@@ -100,9 +174,7 @@ type (
 )
 
 func (sp *ShellyProberGen1) fetch(url string, response interface{}) error {
-	r := sp.Client.R().ForceContentType("application/json").SetResult(&response)
-	_, err := r.Get(url)
-	return err
+	return Fetch(sp.Ctx, sp.Client, url, response, sp.RequestObserver)
 }
 
 func (sp *ShellyProberGen1) GetSettings() (ShellyProberGen1ResultSettings, error) {
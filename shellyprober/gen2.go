@@ -3,7 +3,9 @@ package shellyprober
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	resty "github.com/go-resty/resty/v2"
 	"github.com/patrickmn/go-cache"
@@ -11,16 +13,29 @@ import (
 	"github.com/webdevops/shelly-plug-exporter/discovery"
 )
 
+var (
+	// ErrNoClient is returned for requests of probers without client (status only probers)
+	ErrNoClient = errors.New(`no client available, status not included in preloaded status`)
+)
+
 type (
 	ShellyProberGen2 struct {
 		Target discovery.DiscoveryTarget
 		Client *resty.Client
 		Ctx    context.Context
 		Cache  *cache.Cache
+
+		// RequestObserver is called after each device request (optional)
+		RequestObserver func(endpoint string, duration time.Duration, err error)
+
+		// Status is the preloaded Shelly.GetStatus document, see LoadShellyStatus
+		Status ShellyProberGen2ResultShellyStatus
 	}
 
 	ShellyProberGen2ResultShellyConfig map[string]interface{}
 
+	ShellyProberGen2ResultShellyStatus map[string]interface{}
+
 	ShellyProberGen2ResultSysStatus struct {
 		Mac              string `json:"mac"`
 		RestartRequired  bool   `json:"restart_required"`
@@ -55,6 +70,40 @@ type (
 		TF float64 `json:"tF"`
 	}
 
+	ShellyProberGen2ResultHumidity struct {
+		ID int      `json:"id"`
+		Rh *float64 `json:"rh"`
+	}
+
+	ShellyProberGen2ResultIlluminance struct {
+		ID           int      `json:"id"`
+		Lux          *float64 `json:"lux"`
+		Illumination string   `json:"illumination"`
+	}
+
+	ShellyProberGen2ResultVoltmeter struct {
+		ID       int      `json:"id"`
+		Voltage  *float64 `json:"voltage"`
+		Xvoltage *float64 `json:"xvoltage"`
+	}
+
+	ShellyProberGen2ResultDevicePower struct {
+		ID      int `json:"id"`
+		Battery struct {
+			V       *float64 `json:"V"`
+			Percent *float64 `json:"percent"`
+		} `json:"battery"`
+		External struct {
+			Present bool `json:"present"`
+		} `json:"external"`
+	}
+
+	ShellyProberGen2ResultFlood struct {
+		ID    int  `json:"id"`
+		Alarm bool `json:"alarm"`
+		Mute  bool `json:"mute"`
+	}
+
 	ShellyProberGen2ResultSwitch struct {
 		ID      int     `json:"id"`
 		Source  string  `json:"source"`
@@ -67,10 +116,102 @@ type (
 			ByMinute []float64 `json:"by_minute"`
 			MinuteTs float64   `json:"minute_ts"`
 		} `json:"aenergy"`
+		// only reported by devices which are able to measure returned energy
+		RetAenergy *struct {
+			Total    float64   `json:"total"`
+			ByMinute []float64 `json:"by_minute"`
+			MinuteTs float64   `json:"minute_ts"`
+		} `json:"ret_aenergy"`
+		Temperature struct {
+			TC *float64 `json:"tC"`
+			TF *float64 `json:"tF"`
+		} `json:"temperature"`
+		Errors []string `json:"errors"`
+	}
+
+	ShellyProberGen2ResultPm1 struct {
+		ID        int     `json:"id"`
+		Voltage   float64 `json:"voltage"`
+		Current   float64 `json:"current"`
+		Apower    float64 `json:"apower"`
+		Aprtpower float64 `json:"aprtpower"`
+		Pf        float64 `json:"pf"`
+		Freq      float64 `json:"freq"`
+		Aenergy   struct {
+			Total    float64   `json:"total"`
+			ByMinute []float64 `json:"by_minute"`
+			MinuteTs float64   `json:"minute_ts"`
+		} `json:"aenergy"`
+		// only reported by devices which are able to measure returned energy
+		RetAenergy *struct {
+			Total    float64   `json:"total"`
+			ByMinute []float64 `json:"by_minute"`
+			MinuteTs float64   `json:"minute_ts"`
+		} `json:"ret_aenergy"`
+	}
+
+	// ShellyProberGen2ResultLight is used for light, rgb and rgbw components
+	ShellyProberGen2ResultLight struct {
+		ID         int       `json:"id"`
+		Source     string    `json:"source"`
+		Output     bool      `json:"output"`
+		Brightness *float64  `json:"brightness"`
+		Rgb        []float64 `json:"rgb"`
+		White      *float64  `json:"white"`
+		Ct         *float64  `json:"ct"`
+		Apower     *float64  `json:"apower"`
+		Voltage    *float64  `json:"voltage"`
+		Current    *float64  `json:"current"`
+		Aenergy    *struct {
+			Total    float64   `json:"total"`
+			ByMinute []float64 `json:"by_minute"`
+			MinuteTs float64   `json:"minute_ts"`
+		} `json:"aenergy"`
+		Temperature struct {
+			TC *float64 `json:"tC"`
+			TF *float64 `json:"tF"`
+		} `json:"temperature"`
+		Errors []string `json:"errors"`
+	}
+
+	ShellyProberGen2ResultInput struct {
+		ID       int      `json:"id"`
+		State    *bool    `json:"state"`
+		Percent  *float64 `json:"percent"`
+		Xpercent *float64 `json:"xpercent"`
+		Counts   *struct {
+			Total    float64   `json:"total"`
+			Xtotal   *float64  `json:"xtotal"`
+			ByMinute []float64 `json:"by_minute"`
+			MinuteTs float64   `json:"minute_ts"`
+		} `json:"counts"`
+		Freq  *float64 `json:"freq"`
+		Xfreq *float64 `json:"xfreq"`
+	}
+
+	ShellyProberGen2ResultCover struct {
+		ID      int     `json:"id"`
+		Source  string  `json:"source"`
+		State   string  `json:"state"`
+		Apower  float64 `json:"apower"`
+		Voltage float64 `json:"voltage"`
+		Current float64 `json:"current"`
+		Pf      float64 `json:"pf"`
+		Freq    float64 `json:"freq"`
+		Aenergy struct {
+			Total    float64   `json:"total"`
+			ByMinute []float64 `json:"by_minute"`
+			MinuteTs float64   `json:"minute_ts"`
+		} `json:"aenergy"`
 		Temperature struct {
-			TC float64 `json:"tC"`
-			TF float64 `json:"tF"`
+			TC *float64 `json:"tC"`
+			TF *float64 `json:"tF"`
 		} `json:"temperature"`
+		PosControl    bool     `json:"pos_control"`
+		CurrentPos    *float64 `json:"current_pos"`
+		TargetPos     *float64 `json:"target_pos"`
+		LastDirection *string  `json:"last_direction"`
+		Errors        []string `json:"errors"`
 	}
 
 	ShellyProberGen2ResultEm struct {
@@ -100,6 +241,22 @@ type (
 		UserCalibratedPhase []any   `json:"user_calibrated_phase"`
 	}
 
+	ShellyProberGen2ResultEm1 struct {
+		ID        int     `json:"id"`
+		Current   float64 `json:"current"`
+		Voltage   float64 `json:"voltage"`
+		ActPower  float64 `json:"act_power"`
+		AprtPower float64 `json:"aprt_power"`
+		Pf        float64 `json:"pf"`
+		Freq      float64 `json:"freq"`
+	}
+
+	ShellyProberGen2ResultEm1Data struct {
+		ID                int     `json:"id"`
+		TotalActEnergy    float64 `json:"total_act_energy"`
+		TotalActRetEnergy float64 `json:"total_act_ret_energy"`
+	}
+
 	ShellyProberGen2ResultEmData struct {
 		ID                 int     `json:"id"`
 		ATotalActEnergy    float64 `json:"a_total_act_energy"`
@@ -114,9 +271,11 @@ type (
 )
 
 func (sp *ShellyProberGen2) fetch(url string, response interface{}) error {
-	r := sp.Client.R().ForceContentType("application/json").SetResult(&response)
-	_, err := r.Get(url)
-	return err
+	if sp.Client == nil {
+		return ErrNoClient
+	}
+
+	return Fetch(sp.Ctx, sp.Client, url, response, sp.RequestObserver)
 }
 
 func (sp *ShellyProberGen2) fetchWithCache(url string, response interface{}) error {
@@ -137,9 +296,41 @@ func (sp *ShellyProberGen2) fetchWithCache(url string, response interface{}) err
 	return err
 }
 
+// fetchComponent decodes the component status from the preloaded Shelly.GetStatus document,
+// falls back to a dedicated request if the component is not available there
+func (sp *ShellyProberGen2) fetchComponent(component, url string, response interface{}) error {
+	if val, ok := sp.Status[component]; ok {
+		if data, err := json.Marshal(val); err == nil {
+			if err := json.Unmarshal(data, response); err == nil {
+				return nil
+			}
+		}
+	}
+
+	return sp.fetch(url, response)
+}
+
+func (sp *ShellyProberGen2) GetShellyStatus() (ShellyProberGen2ResultShellyStatus, error) {
+	result := ShellyProberGen2ResultShellyStatus{}
+	err := sp.fetch("/rpc/Shelly.GetStatus", &result)
+	return result, err
+}
+
+// LoadShellyStatus fetches the status of all components with one request,
+// following component status calls are decoded from this document
+func (sp *ShellyProberGen2) LoadShellyStatus() error {
+	result, err := sp.GetShellyStatus()
+	if err != nil {
+		return err
+	}
+
+	sp.Status = result
+	return nil
+}
+
 func (sp *ShellyProberGen2) GetSysStatus() (ShellyProberGen2ResultSysStatus, error) {
 	result := ShellyProberGen2ResultSysStatus{}
-	err := sp.fetch("/rpc/Sys.GetStatus", &result)
+	err := sp.fetchComponent("sys", "/rpc/Sys.GetStatus", &result)
 	return result, err
 }
 
@@ -151,30 +342,108 @@ func (sp *ShellyProberGen2) GetShellyConfig() (ShellyProberGen2ResultShellyConfi
 
 func (sp *ShellyProberGen2) GetWifiStatus() (ShellyProberGen2ResultWifiStatus, error) {
 	result := ShellyProberGen2ResultWifiStatus{}
-	err := sp.fetch("/rpc/Wifi.GetStatus", &result)
+	err := sp.fetchComponent("wifi", "/rpc/Wifi.GetStatus", &result)
 	return result, err
 }
 
 func (sp *ShellyProberGen2) GetTemperatureStatus(id int) (ShellyProberGen2ResultTemperature, error) {
 	result := ShellyProberGen2ResultTemperature{}
-	err := sp.fetch(fmt.Sprintf("/rpc/Temperature.GetStatus?id=%d", id), &result)
+	err := sp.fetchComponent(fmt.Sprintf("temperature:%d", id), fmt.Sprintf("/rpc/Temperature.GetStatus?id=%d", id), &result)
 	return result, err
 }
 
 func (sp *ShellyProberGen2) GetSwitchStatus(id int) (ShellyProberGen2ResultSwitch, error) {
 	result := ShellyProberGen2ResultSwitch{}
-	err := sp.fetch(fmt.Sprintf("/rpc/Switch.GetStatus?id=%d", id), &result)
+	err := sp.fetchComponent(fmt.Sprintf("switch:%d", id), fmt.Sprintf("/rpc/Switch.GetStatus?id=%d", id), &result)
 	return result, err
 }
 
 func (sp *ShellyProberGen2) GetEmStatus(id int) (ShellyProberGen2ResultEm, error) {
 	result := ShellyProberGen2ResultEm{}
-	err := sp.fetch(fmt.Sprintf("/rpc/Em.GetStatus?id=%d", id), &result)
+	err := sp.fetchComponent(fmt.Sprintf("em:%d", id), fmt.Sprintf("/rpc/Em.GetStatus?id=%d", id), &result)
 	return result, err
 }
 
 func (sp *ShellyProberGen2) GetEmDataStatus(id int) (ShellyProberGen2ResultEmData, error) {
 	result := ShellyProberGen2ResultEmData{}
-	err := sp.fetch(fmt.Sprintf("/rpc/EmData.GetStatus?id=%d", id), &result)
+	err := sp.fetchComponent(fmt.Sprintf("emdata:%d", id), fmt.Sprintf("/rpc/EmData.GetStatus?id=%d", id), &result)
+	return result, err
+}
+
+func (sp *ShellyProberGen2) GetPm1Status(id int) (ShellyProberGen2ResultPm1, error) {
+	result := ShellyProberGen2ResultPm1{}
+	err := sp.fetchComponent(fmt.Sprintf("pm1:%d", id), fmt.Sprintf("/rpc/PM1.GetStatus?id=%d", id), &result)
+	return result, err
+}
+
+func (sp *ShellyProberGen2) GetEm1Status(id int) (ShellyProberGen2ResultEm1, error) {
+	result := ShellyProberGen2ResultEm1{}
+	err := sp.fetchComponent(fmt.Sprintf("em1:%d", id), fmt.Sprintf("/rpc/EM1.GetStatus?id=%d", id), &result)
+	return result, err
+}
+
+func (sp *ShellyProberGen2) GetEm1DataStatus(id int) (ShellyProberGen2ResultEm1Data, error) {
+	result := ShellyProberGen2ResultEm1Data{}
+	err := sp.fetchComponent(fmt.Sprintf("em1data:%d", id), fmt.Sprintf("/rpc/EM1Data.GetStatus?id=%d", id), &result)
+	return result, err
+}
+
+func (sp *ShellyProberGen2) GetCoverStatus(id int) (ShellyProberGen2ResultCover, error) {
+	result := ShellyProberGen2ResultCover{}
+	err := sp.fetchComponent(fmt.Sprintf("cover:%d", id), fmt.Sprintf("/rpc/Cover.GetStatus?id=%d", id), &result)
+	return result, err
+}
+
+func (sp *ShellyProberGen2) GetLightStatus(id int) (ShellyProberGen2ResultLight, error) {
+	result := ShellyProberGen2ResultLight{}
+	err := sp.fetchComponent(fmt.Sprintf("light:%d", id), fmt.Sprintf("/rpc/Light.GetStatus?id=%d", id), &result)
+	return result, err
+}
+
+func (sp *ShellyProberGen2) GetRgbStatus(id int) (ShellyProberGen2ResultLight, error) {
+	result := ShellyProberGen2ResultLight{}
+	err := sp.fetchComponent(fmt.Sprintf("rgb:%d", id), fmt.Sprintf("/rpc/RGB.GetStatus?id=%d", id), &result)
+	return result, err
+}
+
+func (sp *ShellyProberGen2) GetRgbwStatus(id int) (ShellyProberGen2ResultLight, error) {
+	result := ShellyProberGen2ResultLight{}
+	err := sp.fetchComponent(fmt.Sprintf("rgbw:%d", id), fmt.Sprintf("/rpc/RGBW.GetStatus?id=%d", id), &result)
+	return result, err
+}
+
+func (sp *ShellyProberGen2) GetInputStatus(id int) (ShellyProberGen2ResultInput, error) {
+	result := ShellyProberGen2ResultInput{}
+	err := sp.fetchComponent(fmt.Sprintf("input:%d", id), fmt.Sprintf("/rpc/Input.GetStatus?id=%d", id), &result)
+	return result, err
+}
+
+func (sp *ShellyProberGen2) GetHumidityStatus(id int) (ShellyProberGen2ResultHumidity, error) {
+	result := ShellyProberGen2ResultHumidity{}
+	err := sp.fetchComponent(fmt.Sprintf("humidity:%d", id), fmt.Sprintf("/rpc/Humidity.GetStatus?id=%d", id), &result)
+	return result, err
+}
+
+func (sp *ShellyProberGen2) GetIlluminanceStatus(id int) (ShellyProberGen2ResultIlluminance, error) {
+	result := ShellyProberGen2ResultIlluminance{}
+	err := sp.fetchComponent(fmt.Sprintf("illuminance:%d", id), fmt.Sprintf("/rpc/Illuminance.GetStatus?id=%d", id), &result)
+	return result, err
+}
+
+func (sp *ShellyProberGen2) GetVoltmeterStatus(id int) (ShellyProberGen2ResultVoltmeter, error) {
+	result := ShellyProberGen2ResultVoltmeter{}
+	err := sp.fetchComponent(fmt.Sprintf("voltmeter:%d", id), fmt.Sprintf("/rpc/Voltmeter.GetStatus?id=%d", id), &result)
+	return result, err
+}
+
+func (sp *ShellyProberGen2) GetDevicePowerStatus(id int) (ShellyProberGen2ResultDevicePower, error) {
+	result := ShellyProberGen2ResultDevicePower{}
+	err := sp.fetchComponent(fmt.Sprintf("devicepower:%d", id), fmt.Sprintf("/rpc/DevicePower.GetStatus?id=%d", id), &result)
+	return result, err
+}
+
+func (sp *ShellyProberGen2) GetFloodStatus(id int) (ShellyProberGen2ResultFlood, error) {
+	result := ShellyProberGen2ResultFlood{}
+	err := sp.fetchComponent(fmt.Sprintf("flood:%d", id), fmt.Sprintf("/rpc/Flood.GetStatus?id=%d", id), &result)
 	return result, err
 }
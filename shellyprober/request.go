@@ -0,0 +1,129 @@
+package shellyprober
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	resty "github.com/go-resty/resty/v2"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// RequestGrace is kept free of the remaining probe deadline for decoding and sending the (partial) results
+	RequestGrace = 100 * time.Millisecond
+
+	// DefaultRequestTimeout is the timeout of one request attempt if the client has no timeout
+	DefaultRequestTimeout = 30 * time.Second
+)
+
+var (
+	// requestGroup deduplicates concurrent requests of the same device endpoint (eg. multiple Prometheus instances)
+	requestGroup singleflight.Group
+)
+
+type (
+	// AttemptTransport limits every request attempt (including retries) to min(timeout, remaining deadline - RequestGrace)
+	AttemptTransport struct {
+		Transport http.RoundTripper
+		Timeout   time.Duration
+	}
+
+	// cancelBody cancels the context of the request attempt when the response body is closed
+	cancelBody struct {
+		io.ReadCloser
+		cancel context.CancelFunc
+	}
+)
+
+// Fetch requests the endpoint of the device and decodes the json response, concurrent requests of the same
+// endpoint are sharing one in-flight request. The request is bound to the probe context: retries are stopped
+// as soon as the deadline is reached and each attempt is limited by AttemptTransport of the client
+func Fetch(ctx context.Context, client *resty.Client, url string, response interface{}, observer func(endpoint string, duration time.Duration, err error)) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	startTime := time.Now()
+	body, err := fetchShared(ctx, client, url)
+	if err == nil {
+		err = json.Unmarshal(body, response)
+	}
+
+	if observer != nil {
+		observer(url, time.Since(startTime), err)
+	}
+	return err
+}
+
+func fetchShared(ctx context.Context, client *resty.Client, url string) ([]byte, error) {
+	resultChan := requestGroup.DoChan(client.BaseURL+url, func() (interface{}, error) {
+		// the shared request must not be cancelled with the first caller, it's limited by an own timeout instead
+		sharedCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedRequestTimeout(client))
+		defer cancel()
+
+		res, err := client.R().SetContext(sharedCtx).Get(url)
+		if err != nil {
+			return nil, err
+		}
+		return res.Body(), nil
+	})
+
+	// every caller only waits as long as its own context allows
+	select {
+	case result := <-resultChan:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return result.Val.([]byte), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// sharedRequestTimeout returns the fixed timeout of a shared request (all attempts and the waits between them).
+// The deadline of the first caller is not used as callers with a longer deadline would fail with the shorter one,
+// every caller stops waiting at its own deadline instead
+func sharedRequestTimeout(client *resty.Client) time.Duration {
+	timeout := client.GetClient().Timeout
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	return timeout*time.Duration(client.RetryCount+1) + client.RetryMaxWaitTime*time.Duration(client.RetryCount)
+}
+
+func (t *AttemptTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	timeout := t.Timeout
+	if deadline, ok := req.Context().Deadline(); ok {
+		remaining := time.Until(deadline) - RequestGrace
+		if remaining <= 0 {
+			// no budget left for another attempt
+			return nil, context.DeadlineExceeded
+		}
+		if timeout <= 0 || remaining < timeout {
+			timeout = remaining
+		}
+	}
+
+	if timeout <= 0 {
+		return t.Transport.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := t.Transport.RoundTrip(req.WithContext(ctx))
+	if err != nil || resp.Body == nil {
+		cancel()
+		return resp, err
+	}
+
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
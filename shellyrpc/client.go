@@ -0,0 +1,158 @@
+package shellyrpc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevops/shelly-plug-exporter/credentials"
+	"github.com/webdevops/shelly-plug-exporter/discovery"
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+const (
+	reconnectWaitTimeMin = 1 * time.Second
+	reconnectWaitTimeMax = 1 * time.Minute
+)
+
+type (
+	// Client keeps a websocket connection to the rpc endpoint of a gen2+ device
+	// and mirrors the device status (Shelly.GetStatus and NotifyStatus/NotifyFullStatus) into the store
+	Client struct {
+		logger *slogger.Logger
+		store  *shellystate.Store
+		target discovery.DiscoveryTarget
+
+		credential *credentials.Credential
+		resync     time.Duration
+		src        string
+
+		connected atomic.Bool
+	}
+)
+
+func NewClient(logger *slogger.Logger, store *shellystate.Store, target discovery.DiscoveryTarget, credential *credentials.Credential, resync time.Duration) *Client {
+	return &Client{
+		logger:     logger,
+		store:      store,
+		target:     target,
+		credential: credential,
+		resync:     resync,
+		src:        fmt.Sprintf("shelly-plug-exporter-%s", randomHex(4)),
+	}
+}
+
+// IsConnected returns true if the websocket connection is established and the status was received
+func (c *Client) IsConnected() bool {
+	return c.connected.Load()
+}
+
+// Run connects to the device and reconnects (with backoff) until the context is cancelled
+func (c *Client) Run(ctx context.Context) {
+	waitTime := reconnectWaitTimeMin
+	for {
+		startTime := time.Now()
+		err := c.run(ctx)
+
+		c.connected.Store(false)
+		// the device might be mirrored by a new client already (eg. target was changed)
+		c.store.RemoveOwnedDevice(c.target.Key(), c.src)
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		// reset backoff if connection was running for some time
+		if time.Since(startTime) > reconnectWaitTimeMax {
+			waitTime = reconnectWaitTimeMin
+		}
+
+		c.logger.Warn("websocket connection failed, reconnecting", slog.Any("error", err), slog.Duration("wait", waitTime))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(waitTime):
+		}
+
+		waitTime = min(waitTime*2, reconnectWaitTimeMax)
+	}
+}
+
+func (c *Client) run(ctx context.Context) error {
+	url := strings.Replace(c.target.BaseUrl(), "http://", "ws://", 1) + "/rpc"
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return err
+	}
+
+	rpc := newRpcConnection(conn, c.src, c.credential)
+	defer rpc.Close() // nolint:errcheck
+	c.logger.Debug("websocket connected", slog.String("url", url))
+
+	// close connection on shutdown (or failed resync) to unblock the reader
+	connCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-connCtx.Done()
+		rpc.Close() // nolint:errcheck
+	}()
+
+	// initial status, also subscribes to notifications
+	if err := rpc.requestStatus(); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := rpc.runResync(connCtx, c.resync); err != nil {
+			c.logger.Warn("failed to request status", slog.Any("error", err))
+			cancel()
+		}
+	}()
+
+	for {
+		frame, err := rpc.readFrame(c.resync * 2)
+		if err != nil {
+			return err
+		}
+
+		if frame != nil {
+			if err := c.handleFrame(rpc, frame); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *Client) handleFrame(rpc *rpcConnection, frame *shellystate.Gen2Frame) error {
+	switch {
+	case frame.Error != nil && frame.Error.Code == 401:
+		return rpc.handleAuthChallenge(frame)
+	case frame.Error != nil:
+		return fmt.Errorf("rpc error %v: %v", frame.Error.Code, frame.Error.Message)
+	case frame.ID != nil && frame.Result != nil:
+		// response to Shelly.GetStatus
+		status, err := rpc.decodeStatus(frame)
+		if err != nil {
+			return err
+		}
+
+		c.store.ApplyGen2Status(c.target.Key(), shellystate.SourceWebsocket, c.target.Address, status, true)
+		c.store.SetOwner(c.target.Key(), c.src)
+		c.connected.Store(true)
+	case frame.Method == "NotifyStatus", frame.Method == "NotifyFullStatus":
+		// only apply notifications after the full status is known
+		if c.connected.Load() {
+			c.store.ApplyGen2Status(c.target.Key(), shellystate.SourceWebsocket, c.target.Address, frame.Params, frame.Method == "NotifyFullStatus")
+		}
+	}
+
+	return nil
+}
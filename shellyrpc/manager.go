@@ -0,0 +1,134 @@
+package shellyrpc
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevops/shelly-plug-exporter/credentials"
+	"github.com/webdevops/shelly-plug-exporter/discovery"
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+const (
+	// interval for syncing the websocket clients with the discovered targets
+	syncInterval = 30 * time.Second
+)
+
+type (
+	// Manager maintains one websocket client per gen2+ discovery target
+	Manager struct {
+		logger *slogger.Logger
+		store  *shellystate.Store
+
+		credentials *credentials.Store
+		resync      time.Duration
+
+		lock    sync.RWMutex
+		clients map[string]*managedClient
+	}
+
+	managedClient struct {
+		client *Client
+		target discovery.DiscoveryTarget
+		cancel context.CancelFunc
+	}
+)
+
+func NewManager(logger *slogger.Logger, resync time.Duration) *Manager {
+	return &Manager{
+		logger:  logger,
+		store:   shellystate.NewStore(resync * 2),
+		resync:  resync,
+		clients: map[string]*managedClient{},
+	}
+}
+
+// SetCredentials sets the credential store for devices with enabled authentication
+func (m *Manager) SetCredentials(store *credentials.Store) {
+	m.credentials = store
+}
+
+// Run syncs the clients with the service discovery targets
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		if discovery.ServiceDiscovery != nil {
+			m.Sync(ctx, discovery.ServiceDiscovery.GetTargetList())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync starts clients for new gen2+ targets and stops clients of removed (or changed) targets
+func (m *Manager) Sync(ctx context.Context, targets []discovery.DiscoveryTarget) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	current := map[string]discovery.DiscoveryTarget{}
+	for _, target := range targets {
+		if target.Type == discovery.TargetTypeShellyPlug {
+			// gen1 devices are not supporting websockets
+			continue
+		}
+		// clients are keyed by device id (see DiscoveryTarget.Key), the address might change (eg. DHCP)
+		current[target.Key()] = target
+	}
+
+	for key, entry := range m.clients {
+		if target, ok := current[key]; !ok || target.Address != entry.target.Address || target.Port != entry.target.Port {
+			m.logger.Info("stopping websocket client", slog.String("target", entry.target.Name()))
+			entry.cancel()
+			delete(m.clients, key)
+		}
+	}
+
+	for key, target := range current {
+		if _, ok := m.clients[key]; ok {
+			continue
+		}
+
+		m.logger.Info("starting websocket client", slog.String("target", target.Name()))
+		clientCtx, cancel := context.WithCancel(ctx)
+		credential := target.Auth
+		if credential == nil {
+			credential = m.credentials.Lookup(target.Hostname, target.Address, "")
+		}
+		if credential != nil {
+			m.logger.Debug("using credentials", slog.String("target", target.Name()), slog.String("credentials", credential.Name))
+		}
+
+		client := NewClient(
+			m.logger.With(slog.String("target", target.Name())),
+			m.store,
+			target,
+			credential,
+			m.resync,
+		)
+		m.clients[key] = &managedClient{client: client, target: target, cancel: cancel}
+		go client.Run(clientCtx)
+	}
+}
+
+// GetDevice returns the mirrored device state of the target (see DiscoveryTarget.Key) if the websocket client is connected
+func (m *Manager) GetDevice(key string) (shellystate.Device, bool) {
+	m.lock.RLock()
+	entry, ok := m.clients[key]
+	m.lock.RUnlock()
+
+	if !ok || !entry.client.IsConnected() {
+		return shellystate.Device{}, false
+	}
+
+	return m.store.GetDevice(key)
+}
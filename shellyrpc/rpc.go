@@ -0,0 +1,196 @@
+package shellyrpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/webdevops/shelly-plug-exporter/credentials"
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+const (
+	// gen2 devices are always using admin as username
+	authUsername = "admin"
+
+	writeTimeout = 5 * time.Second
+)
+
+type (
+	// rpcConnection is a websocket connection using gen2 rpc frames (used for client and server connections)
+	rpcConnection struct {
+		conn       *websocket.Conn
+		src        string
+		credential *credentials.Credential
+
+		writeLock    sync.Mutex
+		requestId    int
+		auth         *requestAuth
+		authFailures int
+	}
+
+	request struct {
+		ID     int          `json:"id"`
+		Src    string       `json:"src"`
+		Method string       `json:"method"`
+		Auth   *requestAuth `json:"auth,omitempty"`
+	}
+
+	requestAuth struct {
+		Realm     string `json:"realm"`
+		Username  string `json:"username"`
+		Nonce     int64  `json:"nonce"`
+		Cnonce    string `json:"cnonce"`
+		Response  string `json:"response"`
+		Algorithm string `json:"algorithm"`
+	}
+
+	authChallenge struct {
+		AuthType  string `json:"auth_type"`
+		Nonce     int64  `json:"nonce"`
+		Nc        int    `json:"nc"`
+		Realm     string `json:"realm"`
+		Algorithm string `json:"algorithm"`
+	}
+)
+
+func newRpcConnection(conn *websocket.Conn, src string, credential *credentials.Credential) *rpcConnection {
+	return &rpcConnection{
+		conn:       conn,
+		src:        src,
+		credential: credential,
+	}
+}
+
+// readFrame reads the next rpc frame, the device has to send something (at least the resync response) within the timeout
+func (c *rpcConnection) readFrame(timeout time.Duration) (*shellystate.Gen2Frame, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	_, message, err := c.conn.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	frame, err := shellystate.ParseGen2Frame(message)
+	if err != nil {
+		// not a rpc frame, ignore message
+		return nil, nil
+	}
+
+	return frame, nil
+}
+
+// requestStatus sends a Shelly.GetStatus request, this also subscribes the connection to status notifications
+func (c *rpcConnection) requestStatus() error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.requestId++
+	if err := c.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+
+	return c.conn.WriteJSON(request{
+		ID:     c.requestId,
+		Src:    c.src,
+		Method: "Shelly.GetStatus",
+		Auth:   c.auth,
+	})
+}
+
+// runResync requests the full status periodically until the context is cancelled, also detects dead connections
+func (c *rpcConnection) runResync(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := c.requestStatus(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleAuthChallenge answers the authentication challenge (401 error frame) and resends the status request
+func (c *rpcConnection) handleAuthChallenge(frame *shellystate.Gen2Frame) error {
+	if c.credential == nil {
+		return errors.New("device requires authentication but no credentials are configured")
+	}
+
+	password, err := c.credential.GetPassword()
+	if err != nil {
+		return fmt.Errorf("failed to read password of credentials %q: %w", c.credential.Name, err)
+	}
+
+	// first challenge is expected, the second one might be an expired nonce, otherwise credentials are wrong
+	c.authFailures++
+	if c.authFailures > 2 {
+		return errors.New("authentication failed")
+	}
+
+	challenge := authChallenge{}
+	if err := json.Unmarshal([]byte(frame.Error.Message), &challenge); err != nil {
+		return fmt.Errorf("failed to parse authentication challenge: %w", err)
+	}
+
+	c.writeLock.Lock()
+	c.auth = buildAuth(password, challenge)
+	c.writeLock.Unlock()
+
+	return c.requestStatus()
+}
+
+// decodeStatus decodes the Shelly.GetStatus response
+func (c *rpcConnection) decodeStatus(frame *shellystate.Gen2Frame) (map[string]interface{}, error) {
+	status := map[string]interface{}{}
+	if err := json.Unmarshal(frame.Result, &status); err != nil {
+		return nil, fmt.Errorf("failed to decode status: %w", err)
+	}
+
+	c.authFailures = 0
+	return status, nil
+}
+
+func (c *rpcConnection) Close() error {
+	return c.conn.Close()
+}
+
+// buildAuth builds the digest authentication for rpc frames (SHA-256)
+func buildAuth(password string, challenge authChallenge) *requestAuth {
+	cnonce := randomHex(8)
+	ha1 := sha256Hex(fmt.Sprintf("%s:%s:%s", authUsername, challenge.Realm, password))
+	ha2 := sha256Hex("dummy_method:dummy_uri")
+
+	return &requestAuth{
+		Realm:     challenge.Realm,
+		Username:  authUsername,
+		Nonce:     challenge.Nonce,
+		Cnonce:    cnonce,
+		Response:  sha256Hex(fmt.Sprintf("%s:%d:%d:%s:auth:%s", ha1, challenge.Nonce, max(challenge.Nc, 1), cnonce, ha2)),
+		Algorithm: "SHA-256",
+	}
+}
+
+func sha256Hex(val string) string {
+	hash := sha256.Sum256([]byte(val))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomHex(size int) string {
+	buf := make([]byte, size)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,206 @@
+package shellyrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/webdevops/go-common/log/slogger"
+
+	"github.com/webdevops/shelly-plug-exporter/credentials"
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+type (
+	// Server accepts outbound websocket connections of gen2+ devices (eg. remote devices behind NAT)
+	// and mirrors the device status into the store, devices are identified by their src (device id)
+	Server struct {
+		logger *slogger.Logger
+		store  *shellystate.Store
+
+		checkToken  func(r *http.Request) bool
+		maxDevices  int
+		credentials *credentials.Store
+		resync      time.Duration
+		src         string
+
+		upgrader websocket.Upgrader
+
+		lock        sync.Mutex
+		connections map[string]*rpcConnection
+	}
+)
+
+func NewServer(logger *slogger.Logger, store *shellystate.Store, resync time.Duration) *Server {
+	return &Server{
+		logger:      logger,
+		store:       store,
+		resync:      resync,
+		src:         fmt.Sprintf("shelly-plug-exporter-%s", randomHex(4)),
+		connections: map[string]*rpcConnection{},
+	}
+}
+
+// SetTokenCheck enables the token authentication for device connections, only authenticated connections
+// are allowed to replace the existing connection of a device
+func (s *Server) SetTokenCheck(checkToken func(r *http.Request) bool) {
+	s.checkToken = checkToken
+}
+
+// SetMaxDevices limits the number of connected devices (0 = unlimited)
+func (s *Server) SetMaxDevices(maxDevices int) {
+	s.maxDevices = maxDevices
+}
+
+// SetCredentials sets the credential store for devices with enabled authentication
+func (s *Server) SetCredentials(store *credentials.Store) {
+	s.credentials = store
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	authenticated := false
+	if s.checkToken != nil {
+		if !s.checkToken(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		authenticated = true
+	}
+
+	remoteAddress, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remoteAddress = r.RemoteAddr
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Error("failed to upgrade websocket connection", slog.String("address", remoteAddress), slog.Any("error", err))
+		return
+	}
+
+	// credentials are looked up after the device is identified (src of the first frame)
+	rpc := newRpcConnection(conn, s.src, nil)
+	connLogger := s.logger.With(slog.String("address", remoteAddress))
+	connLogger.Debug("device connected")
+
+	if err := s.handleConnection(rpc, remoteAddress, authenticated, connLogger); err != nil {
+		connLogger.Warn("device connection closed", slog.Any("error", err))
+	}
+}
+
+func (s *Server) handleConnection(rpc *rpcConnection, remoteAddress string, authenticated bool, logger *slogger.Logger) error {
+	defer rpc.Close() // nolint:errcheck
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		rpc.Close() // nolint:errcheck
+	}()
+
+	deviceId := ""
+	defer func() {
+		if deviceId != "" {
+			s.unregister(deviceId, rpc)
+		}
+	}()
+
+	// initial status, devices are also sending NotifyFullStatus after connect
+	if err := rpc.requestStatus(); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := rpc.runResync(ctx, s.resync); err != nil {
+			logger.Warn("failed to request status", slog.Any("error", err))
+			cancel()
+		}
+	}()
+
+	for {
+		frame, err := rpc.readFrame(s.resync * 2)
+		if err != nil {
+			return err
+		}
+
+		if frame == nil {
+			continue
+		}
+
+		if deviceId == "" {
+			// device is identified by the first frame
+			if frame.Src == "" {
+				return errors.New("invalid frame, src is missing")
+			}
+			if err := s.register(frame.Src, rpc, authenticated); err != nil {
+				return err
+			}
+			deviceId = frame.Src
+			logger.Info("device registered", slog.String("device", deviceId))
+
+			rpc.credential = s.credentials.Lookup(deviceId, remoteAddress, "")
+			if rpc.credential != nil {
+				logger.Debug("using credentials", slog.String("device", deviceId), slog.String("credentials", rpc.credential.Name))
+			}
+		} else if frame.Src != deviceId {
+			// connections are only allowed to report the status of the registered device
+			logger.Warn("ignoring frame of another device", slog.String("device", deviceId), slog.String("src", frame.Src))
+			continue
+		}
+
+		switch {
+		case frame.Error != nil && frame.Error.Code == 401:
+			if err := rpc.handleAuthChallenge(frame); err != nil {
+				// status is still received via notifications
+				logger.Warn("unable to request status", slog.String("device", deviceId), slog.Any("error", err))
+			}
+		case frame.Error != nil:
+			logger.Warn("received rpc error", slog.String("device", deviceId), slog.Int("code", frame.Error.Code), slog.String("message", frame.Error.Message))
+		case frame.ID != nil && frame.Result != nil:
+			// response to Shelly.GetStatus
+			status, err := rpc.decodeStatus(frame)
+			if err != nil {
+				return err
+			}
+			s.store.ApplyGen2Status(deviceId, shellystate.SourceOutboundWebsocket, remoteAddress, status, true)
+		case frame.Method == "NotifyStatus", frame.Method == "NotifyFullStatus":
+			s.store.ApplyGen2Status(deviceId, shellystate.SourceOutboundWebsocket, remoteAddress, frame.Params, frame.Method == "NotifyFullStatus")
+		}
+	}
+}
+
+// register sets the current connection of the device. Previous connections (eg. reconnect) are only closed
+// by authenticated connections as devices are only identified by their src
+func (s *Server) register(deviceId string, rpc *rpcConnection, authenticated bool) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if previous, ok := s.connections[deviceId]; ok {
+		if !authenticated {
+			return fmt.Errorf(`device "%v" is already connected`, deviceId)
+		}
+		previous.Close() // nolint:errcheck
+	} else if s.maxDevices > 0 && len(s.connections) >= s.maxDevices {
+		return fmt.Errorf(`rejected device "%v", maximum number of devices reached`, deviceId)
+	}
+
+	s.connections[deviceId] = rpc
+	return nil
+}
+
+// unregister removes the device (and the mirrored status) if the connection is still the current one
+func (s *Server) unregister(deviceId string, rpc *rpcConnection) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.connections[deviceId] == rpc {
+		delete(s.connections, deviceId)
+		s.store.RemoveDevice(deviceId)
+	}
+}
@@ -0,0 +1,93 @@
+package shellystate
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SensorTypeTemperature = "T"
+	SensorTypeHumidity    = "H"
+	SensorTypeLuminosity  = "L"
+	SensorTypeBattery     = "B"
+	SensorTypePower       = "P"
+	SensorTypeEnergy      = "E"
+	SensorTypeVoltage     = "V"
+	SensorTypeCurrent     = "I"
+	SensorTypeStatus      = "S"
+	SensorTypeAlarm       = "A"
+	SensorTypeEventCount  = "EVC"
+)
+
+var (
+	// pushValueMapping maps parameters of gen1 action urls ("report sensor values") and gen2 webhooks to sensor values
+	pushValueMapping = map[string]Value{
+		"temp":    {Block: "sensor_0", Type: SensorTypeTemperature, Name: "temperature", Unit: "C"},
+		"tC":      {Block: "sensor_0", Type: SensorTypeTemperature, Name: "temperature", Unit: "C"},
+		"hum":     {Block: "sensor_0", Type: SensorTypeHumidity, Name: "humidity", Unit: "%"},
+		"rh":      {Block: "sensor_0", Type: SensorTypeHumidity, Name: "humidity", Unit: "%"},
+		"lux":     {Block: "sensor_0", Type: SensorTypeLuminosity, Name: "luminosity", Unit: "lux"},
+		"bat":     {Block: "device", Type: SensorTypeBattery, Name: "battery", Unit: "%"},
+		"battery": {Block: "device", Type: SensorTypeBattery, Name: "battery", Unit: "%"},
+		"flood":   {Block: "sensor_0", Type: SensorTypeAlarm, Name: "flood"},
+		"state":   {Block: "sensor_0", Type: SensorTypeStatus, Name: "dwIsOpened"},
+	}
+)
+
+// ApplyValues sets sensor values of a device
+func (s *Store) ApplyValues(id string, generation int, source, address string, values []Value) {
+	s.UpdateDevice(id, func(device *Device) {
+		device.Generation = generation
+		device.Source = source
+		if address != "" {
+			device.Address = address
+		}
+
+		for _, value := range values {
+			if value.Timestamp.IsZero() {
+				value.Timestamp = time.Now()
+			}
+			device.Values[value.Key()] = value
+		}
+	})
+}
+
+// ParsePushValues parses the parameters of gen1 action urls and gen2 webhooks, unknown parameters are ignored
+func ParsePushValues(params url.Values) []Value {
+	ret := []Value{}
+	for name := range params {
+		mapping, ok := pushValueMapping[name]
+		if !ok {
+			continue
+		}
+
+		value, err := ParseValue(params.Get(name))
+		if err != nil {
+			continue
+		}
+		mapping.Value = value
+
+		ret = append(ret, mapping)
+	}
+
+	return ret
+}
+
+// ParseValue parses numeric values and states (eg. on/off, open/close)
+func ParseValue(rawValue string) (float64, error) {
+	switch strings.ToLower(strings.TrimSpace(rawValue)) {
+	case "open", "true", "on":
+		return 1, nil
+	case "close", "closed", "false", "off":
+		return 0, nil
+	default:
+		return strconv.ParseFloat(strings.TrimSpace(rawValue), 64)
+	}
+}
+
+// Key returns the unique key of the value inside a device
+func (v *Value) Key() string {
+	return v.Block + "/" + v.Name
+}
@@ -0,0 +1,116 @@
+package shellystate
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+type (
+	// Gen2Frame is a gen2 RPC frame (notification or response)
+	Gen2Frame struct {
+		ID     *int                   `json:"id,omitempty"`
+		Src    string                 `json:"src"`
+		Dst    string                 `json:"dst,omitempty"`
+		Method string                 `json:"method,omitempty"`
+		Params map[string]interface{} `json:"params,omitempty"`
+		Result json.RawMessage        `json:"result,omitempty"`
+		Error  *Gen2FrameError        `json:"error,omitempty"`
+	}
+
+	Gen2FrameError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+)
+
+// ParseGen2Frame decodes a gen2 RPC frame
+func ParseGen2Frame(data []byte) (*Gen2Frame, error) {
+	frame := Gen2Frame{}
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return nil, err
+	}
+
+	if frame.Src == "" {
+		return nil, errors.New(`invalid frame, src is missing`)
+	}
+
+	return &frame, nil
+}
+
+// ApplyGen2Frame applies NotifyStatus and NotifyFullStatus notifications to the device state,
+// other frames are ignored
+func (s *Store) ApplyGen2Frame(frame *Gen2Frame, source, address string) {
+	switch frame.Method {
+	case "NotifyFullStatus":
+		s.ApplyGen2Status(frame.Src, source, address, frame.Params, true)
+	case "NotifyStatus":
+		s.ApplyGen2Status(frame.Src, source, address, frame.Params, false)
+	}
+}
+
+// ApplyGen2Status applies the component status (partial or full) to the device state
+func (s *Store) ApplyGen2Status(id, source, address string, status map[string]interface{}, full bool) {
+	s.UpdateDevice(id, func(device *Device) {
+		if device.Generation == 0 {
+			device.Generation = 2
+		}
+		device.Source = source
+		if address != "" {
+			device.Address = address
+		}
+
+		components := device.Components
+		if full {
+			device.Components = map[string]interface{}{}
+		}
+
+		for name, value := range status {
+			// notifications contains the timestamp as ts
+			if name == "ts" {
+				continue
+			}
+
+			current, currentIsMap := components[name].(map[string]interface{})
+			update, updateIsMap := value.(map[string]interface{})
+			if currentIsMap && updateIsMap {
+				if outputChanged(current, update) {
+					device.OutputChanges[name]++
+				}
+
+				if !full {
+					mergeComponentStatus(current, update)
+					continue
+				}
+			}
+
+			device.Components[name] = value
+		}
+	})
+}
+
+// outputChanged returns true if the component output (eg. switch on/off) is changed by the update
+func outputChanged(current, update map[string]interface{}) bool {
+	currentOutput, ok := current["output"].(bool)
+	if !ok {
+		return false
+	}
+
+	updateOutput, ok := update["output"].(bool)
+	if !ok {
+		return false
+	}
+
+	return currentOutput != updateOutput
+}
+
+func mergeComponentStatus(current, update map[string]interface{}) {
+	for key, value := range update {
+		if currentValue, ok := current[key].(map[string]interface{}); ok {
+			if updateValue, ok := value.(map[string]interface{}); ok {
+				mergeComponentStatus(currentValue, updateValue)
+				continue
+			}
+		}
+		current[key] = value
+	}
+}
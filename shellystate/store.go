@@ -0,0 +1,228 @@
+package shellystate
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SourcePush              = "push"
+	SourceCoIoT             = "coiot"
+	SourceWebsocket         = "websocket"
+	SourceOutboundWebsocket = "outbound_websocket"
+	SourceMqtt              = "mqtt"
+)
+
+var (
+	deviceMacRegexp = regexp.MustCompile(`^[0-9a-fA-F]{12}$`)
+)
+
+type (
+	// Store keeps the last reported state of devices which are not polled (eg. sleeping battery devices)
+	Store struct {
+		retention  time.Duration
+		maxDevices int
+		devices    map[string]*Device
+		lock       sync.RWMutex
+	}
+
+	Device struct {
+		ID         string    `json:"id"`
+		Generation int       `json:"generation"`
+		Source     string    `json:"source"`
+		Address    string    `json:"address"`
+		Model      string    `json:"model"`
+		MacAddress string    `json:"mac,omitempty"`
+		LastSeen   time.Time `json:"lastSeen"`
+
+		// Owner is the connection mirroring the device state (eg. websocket client), see RemoveOwnedDevice
+		Owner string `json:"-"`
+
+		// Components contains the gen2 component status (same format as Shelly.GetStatus)
+		Components map[string]interface{} `json:"components,omitempty"`
+
+		// OutputChanges contains the number of output changes per component (eg. switch:0) seen in status notifications
+		OutputChanges map[string]int `json:"outputChanges,omitempty"`
+
+		// Values contains the sensor values (CoIoT format, eg. from gen1 action urls)
+		Values map[string]Value `json:"values,omitempty"`
+	}
+
+	Value struct {
+		// Block is the device block (CoIoT naming, eg. device, relay_0, sensor_0)
+		Block string `json:"block"`
+		// Type is the sensor type (CoIoT naming, eg. T for temperature, P for power)
+		Type string `json:"type"`
+		// Name is the sensor description (CoIoT naming, eg. power, energy, output)
+		Name      string    `json:"name"`
+		Unit      string    `json:"unit"`
+		Value     float64   `json:"value"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+)
+
+func NewStore(retention time.Duration) *Store {
+	return &Store{
+		retention: retention,
+		devices:   map[string]*Device{},
+	}
+}
+
+// SetMaxDevices limits the number of devices (0 = unlimited), updates of new devices are ignored if the store is full
+func (s *Store) SetMaxDevices(maxDevices int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.maxDevices = maxDevices
+}
+
+// Accepts returns true if the device is known or the store is not full
+func (s *Store) Accepts(id string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.accepts(id)
+}
+
+func (s *Store) accepts(id string) bool {
+	if _, exists := s.devices[id]; exists || s.maxDevices <= 0 {
+		return true
+	}
+
+	if len(s.devices) >= s.maxDevices {
+		s.cleanup()
+	}
+	return len(s.devices) < s.maxDevices
+}
+
+// UpdateDevice updates (or creates) the device and marks it as seen
+func (s *Store) UpdateDevice(id string, callback func(device *Device)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.accepts(id) {
+		return
+	}
+
+	device, exists := s.devices[id]
+	if !exists {
+		device = &Device{
+			ID:            id,
+			Components:    map[string]interface{}{},
+			OutputChanges: map[string]int{},
+			Values:        map[string]Value{},
+		}
+		s.devices[id] = device
+	}
+
+	callback(device)
+	device.LastSeen = time.Now()
+
+	s.cleanup()
+}
+
+func (s *Store) GetDevice(id string) (Device, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if device, exists := s.devices[id]; exists && !s.isExpired(device) {
+		return device.copy(), true
+	}
+
+	return Device{}, false
+}
+
+func (s *Store) RemoveDevice(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.devices, id)
+}
+
+// SetOwner sets the owner of the device (if the device is known)
+func (s *Store) SetOwner(id, owner string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if device, exists := s.devices[id]; exists {
+		device.Owner = owner
+	}
+}
+
+// RemoveOwnedDevice removes the device only if it's still owned by the owner (eg. not taken over by a new connection)
+func (s *Store) RemoveOwnedDevice(id, owner string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if device, exists := s.devices[id]; exists && device.Owner == owner {
+		delete(s.devices, id)
+	}
+}
+
+func (s *Store) GetDevices() []Device {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	ret := []Device{}
+	for _, device := range s.devices {
+		if !s.isExpired(device) {
+			ret = append(ret, device.copy())
+		}
+	}
+
+	return ret
+}
+
+func (s *Store) isExpired(device *Device) bool {
+	return s.retention > 0 && time.Since(device.LastSeen) > s.retention
+}
+
+func (s *Store) cleanup() {
+	for id, device := range s.devices {
+		if s.isExpired(device) {
+			delete(s.devices, id)
+		}
+	}
+}
+
+// Mac returns the mac address (if announced), from the device status or the device id (eg. shellyplus1pm-a8032ab1b1b0)
+func (d *Device) Mac() string {
+	if d.MacAddress != "" {
+		return d.MacAddress
+	}
+
+	if sys, ok := d.Components["sys"].(map[string]interface{}); ok {
+		if mac, ok := sys["mac"].(string); ok {
+			return mac
+		}
+	}
+
+	if parts := strings.Split(d.ID, "-"); len(parts) >= 2 {
+		if mac := parts[len(parts)-1]; deviceMacRegexp.MatchString(mac) {
+			return strings.ToUpper(mac)
+		}
+	}
+
+	return ""
+}
+
+func (d *Device) copy() Device {
+	ret := *d
+
+	ret.Values = map[string]Value{}
+	for key, value := range d.Values {
+		ret.Values[key] = value
+	}
+
+	ret.OutputChanges = map[string]int{}
+	for key, value := range d.OutputChanges {
+		ret.OutputChanges[key] = value
+	}
+
+	ret.Components = map[string]interface{}{}
+	if data, err := json.Marshal(d.Components); err == nil {
+		_ = json.Unmarshal(data, &ret.Components)
+	}
+
+	return ret
+}
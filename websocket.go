@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/webdevops/shelly-plug-exporter/shellyrpc"
+	"github.com/webdevops/shelly-plug-exporter/shellystate"
+)
+
+var (
+	websocketManager     *shellyrpc.Manager
+	websocketServerStore *shellystate.Store
+)
+
+func initWebsocket() {
+	websocketLogger := logger.With(slog.String("module", "websocket"))
+	websocketManager = shellyrpc.NewManager(websocketLogger, Opts.Shelly.Websocket.Resync)
+	websocketManager.SetCredentials(credentialStore)
+
+	websocketLogger.Info("starting websocket clients for gen2+ devices")
+	go websocketManager.Run(context.Background())
+}
+
+func initWebsocketServer() *shellyrpc.Server {
+	websocketServerStore = shellystate.NewStore(Opts.Shelly.Websocket.Resync * 2)
+
+	server := shellyrpc.NewServer(logger.With(slog.String("module", "websocketserver")), websocketServerStore, Opts.Shelly.Websocket.Resync)
+	server.SetCredentials(credentialStore)
+	server.SetMaxDevices(Opts.Shelly.Websocket.Server.MaxDevices)
+	if token := Opts.Shelly.Websocket.Server.Token; token != "" {
+		server.SetTokenCheck(func(r *http.Request) bool {
+			return checkPushToken(r, token)
+		})
+	}
+
+	return server
+}